@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryAccessLog logs request ID, method, peer, status code and latency of every unary call
+func UnaryAccessLog(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logf(ctx, "grpc %s peer=%s code=%s duration=%v", info.FullMethod, clientIP(ctx), status.Code(err), time.Since(start))
+	return resp, err
+}
+
+// StreamAccessLog logs the opening and closing of every stream with its duration
+func StreamAccessLog(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	peerIP := clientIP(ss.Context())
+	logf(ss.Context(), "grpc %s peer=%s stream opened", info.FullMethod, peerIP)
+	err := handler(srv, ss)
+	logf(ss.Context(), "grpc %s peer=%s stream closed code=%s duration=%v", info.FullMethod, peerIP, status.Code(err), time.Since(start))
+	return err
+}
@@ -1,14 +1,16 @@
 package agent
 
 import (
+	"CanglingAgent/config"
 	"bytes"
 	"context"
 	"fmt"
 	_ "io"
-	"log"
-	"os/exec"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -18,11 +20,18 @@ import (
 type GrpcServer struct {
 	UnimplementedAgentServiceServer
 	Version string
+	Config  config.Config
+	// Docker runs the docker commands of the handlers
+	Docker DockerRunner
 }
 
-func NewGrpcServer() *GrpcServer {
+func NewGrpcServer(config config.Config) *GrpcServer {
+	SetCordoned(CordonOperator, config.Server.Cordoned)
+	SetMaintenanceUntil(config.Server.MaintenanceUntil)
 	return &GrpcServer{
 		Version: "1.0.0",
+		Config:  config,
+		Docker:  TimeoutDocker{Runner: defaultDocker, Timeouts: config.Task.DockerTimeouts},
 	}
 }
 
@@ -31,40 +40,305 @@ func (s *GrpcServer) GetVersion(ctx context.Context, req *Empty) (*VersionRespon
 	return &VersionResponse{Version: s.Version}, nil
 }
 
-// ListTasks implements GET /api/v1/task/ls
-func (s *GrpcServer) ListTasks(ctx context.Context, req *Empty) (*ListTasksResponse, error) {
-	command := exec.Command("docker", "ps", "-a")
-	output, err := command.CombinedOutput()
+// StartTask implements POST /api/v1/task/start
+func (s *GrpcServer) StartTask(ctx context.Context, req *StartTaskRequest) (*StartTaskResponse, error) {
+	files, err := s.validateTask(ctx, req)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to list tasks: %v", err)
+		return nil, err
+	}
+
+	// A retried request must not launch a second container for the same job
+	if req.Id != "" {
+		existingID, err := s.findRunningJob(ctx, req.Id)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to look up job '%s': %v", req.Id, err)
+		}
+		if existingID != "" {
+			return &StartTaskResponse{
+				ContainerId: existingID,
+				Message:     fmt.Sprintf("Job already running. ID: %s", existingID),
+			}, nil
+		}
 	}
-	return &ListTasksResponse{Output: string(output)}, nil
+	if err := s.admitTask(ctx, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyImageDigest(ctx, req.Image); err != nil {
+		return nil, err
+	}
+
+	if req.Name, err = s.resolveNameConflict(ctx, req); err != nil {
+		return nil, err
+	}
+	if req.Privileged {
+		logf(ctx, "WARNING: launching PRIVILEGED task name=%s job=%s image=%s for %s", req.Name, req.Id, req.Image, clientIP(ctx))
+	}
+	args := []string{"run", "--rm", "-d"}
+	if req.Wait {
+		// Keep the container until its output has been collected
+		args = []string{"run", "-d"}
+	}
+	args = append(args, s.buildRunArgs(req, files)...)
+	logExtraArgs(ctx, req, args)
+	containerID, err := s.runContainerCommand(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Wait {
+		response, err := s.waitForExit(ctx, containerID)
+		if response != nil {
+			response.Name = req.Name
+		}
+		return response, err
+	}
+	if s.Config.Task.LogDir != "" {
+		go s.captureLogs(ctx, containerID, req.Name)
+	}
+	return &StartTaskResponse{
+		ContainerId: containerID,
+		Message:     fmt.Sprintf("Job started successfully. ID: %s", containerID),
+		Name:        req.Name,
+	}, nil
 }
 
-// StartTask implements POST /api/v1/task/start
-func (s *GrpcServer) StartTask(ctx context.Context, req *StartTaskRequest) (*StartTaskResponse, error) {
-	// 1. Validation
+// CreateTask creates the container with docker create without starting it, see StartCreatedTask
+func (s *GrpcServer) CreateTask(ctx context.Context, req *StartTaskRequest) (*StartTaskResponse, error) {
+	if req.Wait {
+		return nil, status.Error(codes.InvalidArgument, "Field 'wait' is not supported when only creating a task")
+	}
+	files, err := s.validateTask(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admitTask(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := s.verifyImageDigest(ctx, req.Image); err != nil {
+		return nil, err
+	}
+
+	if req.Name, err = s.resolveNameConflict(ctx, req); err != nil {
+		return nil, err
+	}
+	if req.Privileged {
+		logf(ctx, "WARNING: creating PRIVILEGED task name=%s job=%s image=%s for %s", req.Name, req.Id, req.Image, clientIP(ctx))
+	}
+	args := append([]string{"create", "--rm"}, s.buildRunArgs(req, files)...)
+	logExtraArgs(ctx, req, args)
+	containerID, err := s.runContainerCommand(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return &StartTaskResponse{
+		ContainerId: containerID,
+		Message:     fmt.Sprintf("Job created successfully. ID: %s", containerID),
+		Name:        req.Name,
+	}, nil
+}
+
+// StartCreatedTask starts a container previously made by CreateTask
+func (s *GrpcServer) StartCreatedTask(ctx context.Context, req *StartCreatedTaskRequest) (*StartTaskResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Field 'name' is required")
+	}
+	if IsCordoned() {
+		return nil, status.Error(codes.FailedPrecondition, "Node is cordoned and does not accept new tasks")
+	}
+
+	containerName := s.containerName(req.Name)
+	container, err := s.existingContainer(ctx, containerName)
+	if err != nil {
+		return nil, err
+	}
+	if container == nil {
+		return nil, status.Errorf(codes.NotFound, "Task '%s' does not exist", req.Name)
+	}
+	// The node may have filled up since the task was created, a running container is already counted
+	if !container.State.Running {
+		if err := s.checkCapacity(ctx, createdTaskRequest(container)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.dockerOutput(ctx, "start", containerName); err != nil {
+		return nil, dockerStatus(err.Error(), err.Error())
+	}
+
+	if s.Config.Task.LogDir != "" {
+		go s.captureLogs(ctx, container.Id, req.Name)
+	}
+	return &StartTaskResponse{
+		ContainerId: container.Id,
+		Message:     fmt.Sprintf("Job started successfully. ID: %s", container.Id),
+		Name:        req.Name,
+	}, nil
+}
+
+// createdTaskRequest rebuilds the resources a created container reserves from its labels, for checkCapacity
+func createdTaskRequest(container *containerInspect) *StartTaskRequest {
+	req := &StartTaskRequest{}
+	if memoryMb, err := strconv.Atoi(container.Config.Labels[MemoryLabel]); err == nil {
+		req.MemoryMb = int32(memoryMb)
+	}
+	for _, index := range strings.Split(container.Config.Labels[GpuLabel], ",") {
+		if slot, err := strconv.Atoi(strings.TrimSpace(index)); err == nil {
+			req.Gpus = append(req.Gpus, int32(slot))
+		}
+	}
+	return req
+}
+
+// validateTask runs the request checks shared by StartTask and CreateTask and resolves the agent side files.
+// The checks against what already runs on the node are left to admitTask
+func (s *GrpcServer) validateTask(ctx context.Context, req *StartTaskRequest) (taskFiles, error) {
+	var files taskFiles
+	if IsCordoned() {
+		return files, status.Error(codes.FailedPrecondition, "Node is cordoned and does not accept new tasks")
+	}
+	warnMaintenance(ctx, req)
 	if req.Image == "" {
-		return nil, status.Error(codes.InvalidArgument, "Field 'image' is required")
+		return files, status.Error(codes.InvalidArgument, "Field 'image' is required")
+	}
+	if err := validateRunOptions(req); err != nil {
+		return files, err
+	}
+	if req.ShmSizeMb < 0 {
+		return files, status.Errorf(codes.InvalidArgument, "Field 'shm_size_mb' must be positive, got %d", req.ShmSizeMb)
+	}
+	if maxShm := s.Config.Task.MaxShmSizeMb; maxShm > 0 && req.ShmSizeMb > maxShm {
+		return files, status.Errorf(codes.InvalidArgument, "Field 'shm_size_mb' exceeds the maximum of %dMB", maxShm)
+	}
+	envFiles, err := s.resolveEnvFiles(req.EnvFiles)
+	if err != nil {
+		return files, err
+	}
+	secretMounts, err := s.resolveSecrets(req.Secrets)
+	if err != nil {
+		return files, err
+	}
+	seccompProfile, err := s.resolveSeccompProfile(req.SeccompProfile)
+	if err != nil {
+		return files, err
+	}
+	if err := checkRegistry(req.Image, s.Config.Task.AllowedRegistries); err != nil {
+		return files, err
+	}
+	if err := checkImageAllowed(req.Image, s.Config.Task.AllowedImages); err != nil {
+		return files, err
 	}
 
-	// 2. Construct Docker arguments
-	args := []string{"run", "--rm", "-d"}
+	if req.Network != "" {
+		if err := s.validateNetwork(ctx, req.Network); err != nil {
+			return files, err
+		}
+	}
+
+	if len(req.ExtraArgs) > 0 && !s.Config.Task.AllowExtraArgs {
+		return files, status.Error(codes.FailedPrecondition, "Extra docker arguments are not allowed on this agent")
+	}
+	if req.Privileged && !s.Config.Task.AllowPrivileged {
+		return files, status.Error(codes.FailedPrecondition, "Privileged tasks are not allowed on this agent")
+	}
+	if err := checkDevicesAllowed(req.Devices, s.Config.Task.AllowedDevices); err != nil {
+		return files, err
+	}
+	if err := checkDevicesExist(req.Devices); err != nil {
+		return files, err
+	}
+	return taskFiles{envFiles: envFiles, secretMounts: secretMounts, seccompProfile: seccompProfile}, nil
+}
 
+// admitTask checks the requested GPUs and memory against the tasks already running on the node.
+// StartTask runs it after the job id lookup, so a retried job is not refused for the resources it holds itself
+func (s *GrpcServer) admitTask(ctx context.Context, req *StartTaskRequest) error {
+	if len(req.Gpus) > 0 {
+		if err := s.validateGpus(ctx, req.Gpus, s.Config.Task.RejectBusyGpus); err != nil {
+			return err
+		}
+	}
+	return s.checkCapacity(ctx, req)
+}
+
+// buildRunArgs turns the request into the options, image and command shared by docker run and docker create
+func (s *GrpcServer) buildRunArgs(req *StartTaskRequest, files taskFiles) []string {
+	var args []string
 	if req.Name != "" {
-		args = append(args, "--name", req.Name)
+		args = append(args, "--name", s.containerName(req.Name))
 	}
 
 	for _, env := range req.Envs {
 		args = append(args, "-e", env)
 	}
 
+	for _, envFile := range files.envFiles {
+		args = append(args, "--env-file", envFile)
+	}
+
 	for _, vol := range req.Volumes {
 		args = append(args, "-v", vol)
 	}
 
+	for _, mount := range req.VolumeMounts {
+		args = append(args, "--mount", volumeMountArg(mount, s.Config.Task.NamePrefix))
+	}
+
+	for _, mount := range files.secretMounts {
+		args = append(args, "--mount", mount)
+	}
+
+	if req.Network != "" {
+		args = append(args, "--network", req.Network)
+	}
+
+	for _, server := range req.Dns {
+		args = append(args, "--dns", server)
+	}
+
+	for _, domain := range req.DnsSearch {
+		args = append(args, "--dns-search", domain)
+	}
+
+	for _, alias := range req.HostAliases {
+		args = append(args, "--add-host", alias)
+	}
+
+	if req.WorkingDir != "" {
+		args = append(args, "-w", req.WorkingDir)
+	}
+
+	if req.User != "" {
+		args = append(args, "-u", req.User)
+	}
+
+	if req.ReadOnlyRootfs {
+		args = append(args, "--read-only")
+	}
+
+	for _, mount := range req.Tmpfs {
+		args = append(args, "--tmpfs", mount)
+	}
+
+	for _, ulimit := range req.Ulimits {
+		args = append(args, "--ulimit", ulimit)
+	}
+
 	if req.MemoryMb > 0 {
 		args = append(args, "--memory", fmt.Sprintf("%dm", req.MemoryMb))
+		args = append(args, "--label", fmt.Sprintf("%s=%d", MemoryLabel, req.MemoryMb))
+	}
+	if req.MemorySwapMb == -1 {
+		args = append(args, "--memory-swap", "-1")
+	} else if req.MemorySwapMb > 0 {
+		args = append(args, "--memory-swap", fmt.Sprintf("%dm", req.MemorySwapMb))
+	}
+
+	for _, device := range req.Devices {
+		args = append(args, "--device", device)
+	}
+
+	if req.ShmSizeMb > 0 {
+		args = append(args, "--shm-size", fmt.Sprintf("%dm", req.ShmSizeMb))
 	}
 
 	if len(req.Gpus) > 0 {
@@ -73,75 +347,362 @@ func (s *GrpcServer) StartTask(ctx context.Context, req *StartTaskRequest) (*Sta
 			gpuIDs = append(gpuIDs, strconv.Itoa(int(id)))
 		}
 		args = append(args, "--gpus", fmt.Sprintf("device=%s", strings.Join(gpuIDs, ",")))
+		args = append(args, "--label", fmt.Sprintf("%s=%s", GpuLabel, strings.Join(gpuIDs, ",")))
 	}
 
 	// Labels
-	args = append(args, "--label", "managed-by=cangling-grpc")
+	args = append(args, "--label", ManagedLabel)
+	if s.Config.Task.NamePrefix != "" {
+		args = append(args, "--label", fmt.Sprintf("%s=%s", PrefixLabel, s.Config.Task.NamePrefix))
+	}
 	if req.Id != "" {
 		args = append(args, "--label", fmt.Sprintf("job-id=%s", req.Id))
 	}
+	if req.IdleTimeoutMinutes > 0 {
+		args = append(args, "--label", fmt.Sprintf("%s=%d", IdleTimeoutLabel, req.IdleTimeoutMinutes))
+	}
+
+	if req.Privileged {
+		args = append(args, "--privileged")
+	}
+
+	for _, capability := range req.CapDrop {
+		args = append(args, "--cap-drop", capability)
+	}
+	for _, capability := range req.CapAdd {
+		args = append(args, "--cap-add", capability)
+	}
+
+	if files.seccompProfile != "" {
+		args = append(args, "--security-opt", "seccomp="+files.seccompProfile)
+	}
+	if req.ApparmorProfile != "" {
+		args = append(args, "--security-opt", "apparmor="+req.ApparmorProfile)
+	}
+
+	if req.StopSignal != "" {
+		args = append(args, "--stop-signal", req.StopSignal)
+	}
+	if req.StopTimeoutSeconds > 0 {
+		args = append(args, "--stop-timeout", strconv.Itoa(int(req.StopTimeoutSeconds)))
+	}
+
+	if req.LogDriver != "" {
+		args = append(args, "--log-driver", req.LogDriver)
+	}
+	logOptKeys := make([]string, 0, len(req.LogOpts))
+	for key := range req.LogOpts {
+		logOptKeys = append(logOptKeys, key)
+	}
+	sort.Strings(logOptKeys)
+	for _, key := range logOptKeys {
+		args = append(args, "--log-opt", fmt.Sprintf("%s=%s", key, req.LogOpts[key]))
+	}
+
+	args = append(args, req.ExtraArgs...)
+
+	if req.Entrypoint != "" {
+		args = append(args, "--entrypoint", req.Entrypoint)
+	}
 
 	args = append(args, req.Image)
+	args = append(args, req.Command...)
 
-	// 3. Execute
-	command := exec.CommandContext(ctx, "docker", args...)
+	return args
+}
 
-	var commandOutput bytes.Buffer
-	var commandError bytes.Buffer
-	command.Stdout = &commandOutput
-	command.Stderr = &commandError
+// logExtraArgs records the full command of tasks using extra arguments, which bypass the typed field validation
+func logExtraArgs(ctx context.Context, req *StartTaskRequest, args []string) {
+	if len(req.ExtraArgs) > 0 {
+		logf(ctx, "Task %s uses extra args %q, running: docker %s", req.Name, req.ExtraArgs, strings.Join(args, " "))
+	}
+}
 
-	if err := command.Run(); err != nil {
-		errMsg := fmt.Sprintf("Docker run failed: %s", err.Error())
-		if commandError.Len() > 0 {
-			errMsg += fmt.Sprintf(" | Docker STDERR: %s", commandError.String())
+// volumeMountArg builds the --mount value of a validated mount. Volumes docker creates
+// on the fly get the managed and prefix labels so the janitor of their agent can prune them
+func volumeMountArg(mount *VolumeMount, namePrefix string) string {
+	arg := fmt.Sprintf("type=%s,target=%s", mount.Type, mount.Target)
+	if mount.Source != "" {
+		arg += ",source=" + mount.Source
+	}
+	if mount.Type == "volume" {
+		arg += ",volume-label=" + ManagedLabel
+		if namePrefix != "" {
+			arg += fmt.Sprintf(",volume-label=%s=%s", PrefixLabel, namePrefix)
 		}
-		return nil, status.Error(codes.Internal, errMsg)
+	}
+	if mount.ReadOnly {
+		arg += ",readonly"
+	}
+	return arg
+}
+
+// retryableRunErrors are docker stderr fragments of failures worth retrying, mostly from pulling the image
+var retryableRunErrors = []string{
+	"toomanyrequests",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"connection reset by peer",
+	"net/http: request canceled while waiting for connection",
+}
+
+// runContainerCommand runs docker run or docker create and returns the new container's ID.
+// Transient failures are retried with exponential backoff up to the configured retry count
+func (s *GrpcServer) runContainerCommand(ctx context.Context, args []string) (string, error) {
+	ctx, span := tracer.Start(ctx, "docker "+args[0])
+	defer span.End()
+	containerID, err := s.runWithRetries(ctx, args)
+	return containerID, traceErr(span, err)
+}
+
+func (s *GrpcServer) runWithRetries(ctx context.Context, args []string) (string, error) {
+	backoffSeconds := s.Config.Task.RunRetryBackoffSeconds
+	if backoffSeconds <= 0 {
+		backoffSeconds = 2
+	}
+	backoff := time.Duration(backoffSeconds) * time.Second
+	for attempt := 0; ; attempt++ {
+		stdout, stderr, err := s.Docker.Run(ctx, args...)
+		if err == nil {
+			return strings.TrimSpace(stdout), nil
+		}
+		errMsg := fmt.Sprintf("Docker %s failed: %s", args[0], err.Error())
+		if stderr != "" {
+			errMsg += fmt.Sprintf(" | Docker STDERR: %s", stderr)
+		}
+		errMsg = s.truncateOutput(errMsg)
+		if attempt >= s.Config.Task.RunRetries || !retryableRunError(stderr) {
+			return "", dockerStatus(errMsg, stderr)
+		}
+
+		logf(ctx, "Docker %s failed transiently, retrying in %v (%d/%d): %s",
+			args[0], backoff, attempt+1, s.Config.Task.RunRetries, strings.TrimSpace(stderr))
+		select {
+		case <-ctx.Done():
+			return "", dockerStatus(errMsg, stderr)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func retryableRunError(stderr string) bool {
+	for _, fragment := range retryableRunErrors {
+		if strings.Contains(stderr, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForExit blocks until the container exits or ctx is done, then collects its output and removes it
+func (s *GrpcServer) waitForExit(ctx context.Context, containerID string) (*StartTaskResponse, error) {
+	// Also kills a job that outlived the deadline
+	defer func() {
+		_, _, _ = s.Docker.Run(context.Background(), "rm", "-f", containerID)
+	}()
+
+	waitOutput, _, err := s.Docker.Run(ctx, "wait", containerID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.Errorf(codes.DeadlineExceeded, "Job %s did not finish in time and was killed", containerID)
+		}
+		return nil, status.Errorf(codes.Internal, "Docker wait failed: %v", err)
+	}
+	exitCode, err := strconv.Atoi(strings.TrimSpace(waitOutput))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Unexpected docker wait output: %s", waitOutput)
+	}
+
+	var logOutput bytes.Buffer
+	if err := s.Docker.Stream(context.Background(), &logOutput, &logOutput, "logs", containerID); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to collect job output: %v", err)
 	}
 
-	containerID := strings.TrimSpace(commandOutput.String())
 	return &StartTaskResponse{
 		ContainerId: containerID,
-		Message:     fmt.Sprintf("Job started successfully. ID: %s", containerID),
+		Message:     fmt.Sprintf("Job finished with exit code %d", exitCode),
+		ExitCode:    int32(exitCode),
+		Output:      logOutput.String(),
 	}, nil
 }
 
+// resolveEnvFiles maps the requested env files to paths inside the configured env file directory
+func (s *GrpcServer) resolveEnvFiles(envFiles []string) ([]string, error) {
+	if len(envFiles) == 0 {
+		return nil, nil
+	}
+	if s.Config.Task.EnvFileDir == "" {
+		return nil, status.Error(codes.FailedPrecondition, "Env files are not enabled on this agent")
+	}
+	resolved := make([]string, 0, len(envFiles))
+	for _, envFile := range envFiles {
+		envPath, err := resolveWithin(s.Config.Task.EnvFileDir, envFile)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid env file: %v", err)
+		}
+		resolved = append(resolved, envPath)
+	}
+	return resolved, nil
+}
+
+// resolveSeccompProfile maps a seccomp profile to a file in the security profile directory, "unconfined" is kept as is
+func (s *GrpcServer) resolveSeccompProfile(profile string) (string, error) {
+	if profile == "" || profile == "unconfined" {
+		return profile, nil
+	}
+	if s.Config.Task.SecurityProfileDir == "" {
+		return "", status.Error(codes.FailedPrecondition, "Seccomp profiles are not enabled on this agent")
+	}
+	profilePath, err := resolveWithin(s.Config.Task.SecurityProfileDir, profile)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "Invalid seccomp profile: %v", err)
+	}
+	return profilePath, nil
+}
+
+// findRunningJob returns the ID of the running managed container labelled with jobID, or "" if none
+func (s *GrpcServer) findRunningJob(ctx context.Context, jobID string) (string, error) {
+	return s.findJob(ctx, jobID, "--filter", "status=running")
+}
+
+// findJob returns the ID of a managed container labelled with the job ID, empty when there is none
+func (s *GrpcServer) findJob(ctx context.Context, jobID string, filters ...string) (string, error) {
+	args := append([]string{"ps", "-a", "-q", "--no-trunc"}, s.managedFilters()...)
+	args = append(args, "--filter", fmt.Sprintf("label=job-id=%s", jobID))
+	output, err := s.dockerOutput(ctx, append(args, filters...)...)
+	if err != nil {
+		return "", err
+	}
+	ids := strings.Fields(output)
+	if len(ids) == 0 {
+		return "", nil
+	}
+	return ids[0], nil
+}
+
+// validateNetwork checks that network is one of the docker networks on this node
+func (s *GrpcServer) validateNetwork(ctx context.Context, network string) error {
+	output, err := s.dockerOutput(ctx, "network", "ls", "--format", "{{.Name}}")
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to list networks: %v", err)
+	}
+	networks := strings.Fields(output)
+	for _, name := range networks {
+		if name == network {
+			return nil
+		}
+	}
+	return status.Errorf(codes.InvalidArgument, "Unknown network '%s', available networks: %s", network, strings.Join(networks, ", "))
+}
+
 // StopTask implements GET /api/v1/task/stop
 func (s *GrpcServer) StopTask(ctx context.Context, req *StopTaskRequest) (*StopTaskResponse, error) {
-	targetName := req.Name
-	if targetName == "" {
-		targetName = "agent-test"
+	containerName, targetName, err := s.taskTarget(ctx, req.Name, req.JobId)
+	if err != nil {
+		return nil, err
 	}
 
-	command := exec.CommandContext(ctx, "docker", "stop", targetName)
-	var commandError bytes.Buffer
-	command.Stderr = &commandError
-
-	if err := command.Run(); err != nil {
+	if _, stderr, err := s.Docker.Run(ctx, "stop", containerName); err != nil {
 		// Handle "No such container" gracefully
-		if bytes.Contains(commandError.Bytes(), []byte("No such container")) {
+		if strings.Contains(stderr, "No such container") {
 			return &StopTaskResponse{
 				Message: fmt.Sprintf("Container '%s' was already stopped or does not exist.", targetName),
+				Method:  "none",
 			}, nil
 		}
 
 		errMsg := fmt.Sprintf("Docker stop failed: %s", err.Error())
-		if commandError.Len() > 0 {
-			errMsg += fmt.Sprintf(" | Docker STDERR: %s", commandError.String())
+		if stderr != "" {
+			errMsg += fmt.Sprintf(" | Docker STDERR: %s", stderr)
 		}
-		return nil, status.Error(codes.Internal, errMsg)
+		errMsg = s.truncateOutput(errMsg)
+		return nil, dockerStatus(errMsg, stderr)
 	}
 
+	// Guarantee the container is gone, escalating to kill if it is still running
+	if s.waitUntilStopped(ctx, containerName) {
+		return &StopTaskResponse{
+			Message: fmt.Sprintf("Container '%s' stopped successfully", targetName),
+			Method:  "stop",
+		}, nil
+	}
+	logf(ctx, "Container '%s' still running after stop, killing it", containerName)
+	if _, err := s.dockerOutput(ctx, "kill", containerName); err != nil && !strings.Contains(err.Error(), "No such container") {
+		return nil, status.Errorf(codes.Internal, "Container '%s' survived docker stop and kill failed: %v", targetName, err)
+	}
 	return &StopTaskResponse{
-		Message: fmt.Sprintf("Container '%s' stopped successfully", targetName),
+		Message: fmt.Sprintf("Container '%s' did not stop in time and was killed", targetName),
+		Method:  "kill",
+	}, nil
+}
+
+// waitUntilStopped polls the container until it is no longer running or StopVerifySeconds elapse
+func (s *GrpcServer) waitUntilStopped(ctx context.Context, containerName string) bool {
+	timeout := s.Config.Task.StopVerifySeconds
+	if timeout <= 0 {
+		timeout = 10
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for {
+		output, err := s.dockerOutput(ctx, "inspect", "--format", "{{.State.Running}}", containerName)
+		if err != nil || strings.TrimSpace(output) != "true" {
+			// An auto-removed container no longer exists, which also means it stopped
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// validSignal matches docker signal names (KILL, SIGUSR1, RTMIN+3) and numbers
+var validSignal = regexp.MustCompile(`^([A-Z][A-Z0-9+-]*|[0-9]+)$`)
+
+// KillTask sends a signal to a container, SIGKILL by default, for containers that ignore StopTask
+func (s *GrpcServer) KillTask(ctx context.Context, req *KillTaskRequest) (*KillTaskResponse, error) {
+	containerName, targetName, err := s.taskTarget(ctx, req.Name, req.JobId)
+	if err != nil {
+		return nil, err
+	}
+	signal := strings.ToUpper(req.Signal)
+	if signal == "" {
+		signal = "KILL"
+	}
+	if !validSignal.MatchString(signal) {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid signal '%s'", req.Signal)
+	}
+
+	if _, stderr, err := s.Docker.Run(ctx, "kill", "--signal", signal, containerName); err != nil {
+		// Handle "No such container" gracefully
+		if strings.Contains(stderr, "No such container") {
+			return &KillTaskResponse{
+				Message: fmt.Sprintf("Container '%s' was already stopped or does not exist.", targetName),
+			}, nil
+		}
+
+		errMsg := fmt.Sprintf("Docker kill failed: %s", err.Error())
+		if stderr != "" {
+			errMsg += fmt.Sprintf(" | Docker STDERR: %s", stderr)
+		}
+		return nil, dockerStatus(errMsg, stderr)
+	}
+
+	return &KillTaskResponse{
+		Message: fmt.Sprintf("Signal %s sent to container '%s'", signal, targetName),
 	}, nil
 }
 
 // StreamLogs implements GET /api/v1/task/log
 func (s *GrpcServer) StreamLogs(req *StreamLogsRequest, stream AgentService_StreamLogsServer) error {
-	targetName := req.Name
-	if targetName == "" {
-		targetName = "agent-test"
+	containerName, targetName, err := s.taskTarget(stream.Context(), req.Name, req.JobId)
+	if err != nil {
+		return err
 	}
 	// 1. Send initialization message
 	initMsg := fmt.Sprintf("--- Log Stream Initialized for '%s' ---\n", targetName)
@@ -151,24 +712,29 @@ func (s *GrpcServer) StreamLogs(req *StreamLogsRequest, stream AgentService_Stre
 
 	// 2. Prepare command linked to stream context
 	// When the client disconnects, stream.Context() is canceled, killing the command.
-	cmd := exec.CommandContext(stream.Context(), "docker", "logs", "-f", targetName)
+	args := []string{"logs", "-f"}
+	if req.Tail > 0 {
+		args = append(args, "--tail", strconv.Itoa(int(req.Tail)))
+	}
+	if req.Since != "" {
+		args = append(args, "--since", req.Since)
+	}
+	args = append(args, containerName)
 
 	// 3. Pipe Stdout to the gRPC stream
 	// We use a custom writer to bridge io.Writer -> gRPC Stream
 	logWriter := &LogStreamWriter{Stream: stream}
-	cmd.Stdout = logWriter
 
 	// Capture stderr separately for final error reporting
 	var dockerStderr bytes.Buffer
-	cmd.Stderr = &dockerStderr
 
 	// 4. Run the command
 	// Unlike the HTTP handler, we don't need a manual ticker/flusher here.
 	// gRPC streams flush messages individually.
-	if err := cmd.Run(); err != nil {
+	if err := s.Docker.Stream(stream.Context(), logWriter, &dockerStderr, args...); err != nil {
 		// Check if error is due to client disconnect
 		if stream.Context().Err() != nil {
-			log.Println("Client disconnected from log stream")
+			logf(stream.Context(), "Client disconnected from log stream")
 			return nil // Expected behavior
 		}
 
@@ -0,0 +1,281 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"context"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBuildRunArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		req    *StartTaskRequest
+		files  taskFiles
+		want   []string
+	}{
+		{
+			name: "image only",
+			req:  &StartTaskRequest{Image: "nginx"},
+			want: []string{"--label", ManagedLabel, "nginx"},
+		},
+		{
+			name:   "prefixed name and label",
+			prefix: "team-",
+			req:    &StartTaskRequest{Name: "web", Image: "nginx"},
+			want: []string{"--name", "team-web",
+				"--label", ManagedLabel, "--label", PrefixLabel + "=team-", "nginx"},
+		},
+		{
+			name: "gpus",
+			req:  &StartTaskRequest{Image: "cuda", Gpus: []int32{0, 2}},
+			want: []string{"--gpus", "device=0,2", "--label", GpuLabel + "=0,2",
+				"--label", ManagedLabel, "cuda"},
+		},
+		{
+			name: "mounts",
+			req: &StartTaskRequest{
+				Image:   "nginx",
+				Volumes: []string{"/data:/data"},
+				VolumeMounts: []*VolumeMount{
+					{Type: "volume", Source: "cache", Target: "/cache"},
+					{Type: "bind", Source: "/etc/app", Target: "/etc/app", ReadOnly: true},
+				},
+			},
+			want: []string{"-v", "/data:/data",
+				"--mount", "type=volume,target=/cache,source=cache,volume-label=" + ManagedLabel,
+				"--mount", "type=bind,target=/etc/app,source=/etc/app,readonly",
+				"--label", ManagedLabel, "nginx"},
+		},
+		{
+			name:   "prefixed volume labels",
+			prefix: "team-",
+			req: &StartTaskRequest{Image: "nginx", VolumeMounts: []*VolumeMount{
+				{Type: "volume", Source: "cache", Target: "/cache"},
+			}},
+			want: []string{
+				"--mount", "type=volume,target=/cache,source=cache,volume-label=" + ManagedLabel + ",volume-label=" + PrefixLabel + "=team-",
+				"--label", ManagedLabel, "--label", PrefixLabel + "=team-", "nginx"},
+		},
+		{
+			name:  "env files and secrets",
+			req:   &StartTaskRequest{Image: "nginx", Envs: []string{"A=1"}},
+			files: taskFiles{envFiles: []string{"/env/app.env"}, secretMounts: []string{"type=bind,source=/s/db,target=/run/secrets/db,readonly"}},
+			want: []string{"-e", "A=1", "--env-file", "/env/app.env",
+				"--mount", "type=bind,source=/s/db,target=/run/secrets/db,readonly",
+				"--label", ManagedLabel, "nginx"},
+		},
+		{
+			name: "log driver and sorted options",
+			req: &StartTaskRequest{Image: "nginx", LogDriver: "json-file",
+				LogOpts: map[string]string{"max-size": "10m", "max-file": "3"}},
+			want: []string{"--label", ManagedLabel,
+				"--log-driver", "json-file", "--log-opt", "max-file=3", "--log-opt", "max-size=10m", "nginx"},
+		},
+		{
+			name: "stop signal and timeout",
+			req:  &StartTaskRequest{Image: "nginx", StopSignal: "SIGINT", StopTimeoutSeconds: 30},
+			want: []string{"--label", ManagedLabel, "--stop-signal", "SIGINT", "--stop-timeout", "30", "nginx"},
+		},
+		{
+			name: "extra args before the image",
+			req:  &StartTaskRequest{Image: "nginx", ExtraArgs: []string{"--pids-limit", "100"}},
+			want: []string{"--label", ManagedLabel, "--pids-limit", "100", "nginx"},
+		},
+		{
+			name: "entrypoint before the image, command after it",
+			req:  &StartTaskRequest{Image: "busybox", Entrypoint: "/bin/sh", Command: []string{"-c", "echo hi"}},
+			want: []string{"--label", ManagedLabel, "--entrypoint", "/bin/sh", "busybox", "-c", "echo hi"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{}
+			cfg.Task.NamePrefix = tt.prefix
+			server := &GrpcServer{Config: cfg}
+			if got := server.buildRunArgs(tt.req, tt.files); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildRunArgs =\n  %q\nwant\n  %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartTaskRetryReturnsRunningJob(t *testing.T) {
+	server, docker := newTestServer(config.Config{}, map[string]fakeResult{
+		"ps": {stdout: "abc123\n"},
+	})
+
+	resp, err := server.StartTask(context.Background(), &StartTaskRequest{Id: "job-1", Name: "web", Image: "nginx"})
+	if err != nil {
+		t.Fatalf("StartTask: %v", err)
+	}
+	if resp.ContainerId != "abc123" {
+		t.Errorf("ContainerId = %q, want abc123", resp.ContainerId)
+	}
+	if got := docker.commands("run"); len(got) != 0 {
+		t.Errorf("retried StartTask ran a second container: %q", got)
+	}
+	ps := docker.commands("ps")
+	if len(ps) != 1 || !slices.Contains(ps[0], "label=job-id=job-1") || !slices.Contains(ps[0], "status=running") {
+		t.Errorf("docker ps argv = %q, want a running job-id=job-1 filter", ps)
+	}
+}
+
+func TestStartTaskRetryAtFullCapacity(t *testing.T) {
+	tests := []struct {
+		name      string
+		runningId string
+		code      codes.Code
+	}{
+		{name: "retry of the running job", runningId: "abc123\n"},
+		{name: "new job", code: codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{}
+			cfg.Server.AllocatableMemoryMb = 4096
+			server, docker := newTestServer(cfg, map[string]fakeResult{
+				"ps -a -q --no-trunc": {stdout: tt.runningId},
+				"ps -q":               {stdout: "abc123\n"},
+				"inspect --format":    {stdout: "3221225472\n"},
+			})
+
+			resp, err := server.StartTask(context.Background(), &StartTaskRequest{Id: "job-1", Image: "nginx", MemoryMb: 3072})
+			if status.Code(err) != tt.code {
+				t.Fatalf("StartTask error = %v, want code %v", err, tt.code)
+			}
+			if tt.code == codes.OK && resp.ContainerId != "abc123" {
+				t.Errorf("ContainerId = %q, want the running abc123", resp.ContainerId)
+			}
+			if got := docker.commands("run"); len(got) != 0 {
+				t.Errorf("unexpected docker run: %q", got)
+			}
+		})
+	}
+}
+
+func TestStartTaskEntrypointAndCommand(t *testing.T) {
+	server, docker := newTestServer(config.Config{}, map[string]fakeResult{
+		"run": {stdout: "abc123\n"},
+	})
+
+	req := &StartTaskRequest{Image: "busybox", Entrypoint: "/bin/sh", Command: []string{"-c", "echo $HOME"}}
+	if _, err := server.StartTask(context.Background(), req); err != nil {
+		t.Fatalf("StartTask: %v", err)
+	}
+	want := [][]string{{"run", "--rm", "-d", "--label", ManagedLabel,
+		"--entrypoint", "/bin/sh", "busybox", "-c", "echo $HOME"}}
+	if got := docker.commands("run"); !reflect.DeepEqual(got, want) {
+		t.Errorf("docker run argv =\n  %q\nwant\n  %q", got, want)
+	}
+}
+
+func TestStartTaskDns(t *testing.T) {
+	tests := []struct {
+		name      string
+		dns       []string
+		dnsSearch []string
+		want      []string
+		code      codes.Code
+	}{
+		{
+			name:      "multiple servers and search domains",
+			dns:       []string{"10.0.0.2", "10.0.0.3", "fd00::53"},
+			dnsSearch: []string{"svc.cluster.local", "corp.example.com"},
+			want: []string{"run", "--rm", "-d",
+				"--dns", "10.0.0.2", "--dns", "10.0.0.3", "--dns", "fd00::53",
+				"--dns-search", "svc.cluster.local", "--dns-search", "corp.example.com",
+				"--label", ManagedLabel, "nginx"},
+		},
+		{
+			name: "server that is not an IP",
+			dns:  []string{"10.0.0.2", "dns.example.com"},
+			code: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, docker := newTestServer(config.Config{}, map[string]fakeResult{
+				"run": {stdout: "abc123\n"},
+			})
+			_, err := server.StartTask(context.Background(), &StartTaskRequest{Image: "nginx", Dns: tt.dns, DnsSearch: tt.dnsSearch})
+			if status.Code(err) != tt.code {
+				t.Fatalf("StartTask error = %v, want code %v", err, tt.code)
+			}
+			if tt.code != codes.OK {
+				return
+			}
+			if got := docker.commands("run"); len(got) != 1 || !reflect.DeepEqual(got[0], tt.want) {
+				t.Errorf("docker run argv =\n  %q\nwant\n  %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartTaskDevicesCheckedBeforeStat(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	tests := []struct {
+		name    string
+		allowed []string
+		code    codes.Code
+	}{
+		{name: "disallowed path is not probed", allowed: []string{"/dev/fuse"}, code: codes.FailedPrecondition},
+		{name: "allowed path that is missing", allowed: []string{missing}, code: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{}
+			cfg.Task.AllowedDevices = tt.allowed
+			server, docker := newTestServer(cfg, nil)
+			_, err := server.StartTask(context.Background(), &StartTaskRequest{Image: "nginx", Devices: []string{missing}})
+			if status.Code(err) != tt.code {
+				t.Fatalf("StartTask error = %v, want code %v", err, tt.code)
+			}
+			if got := docker.commands("run"); len(got) != 0 {
+				t.Errorf("docker run called: %q", got)
+			}
+		})
+	}
+}
+
+func TestStartCreatedTaskChecksCapacity(t *testing.T) {
+	created := `[{"Id":"abc123","Name":"/web","State":{"Running":false},` +
+		`"Config":{"Labels":{"` + MemoryLabel + `":"2048"}}}]`
+	tests := []struct {
+		name        string
+		reservedMb  string
+		code        codes.Code
+		wantStarted bool
+	}{
+		{name: "fits", reservedMb: "1073741824", wantStarted: true},
+		{name: "node filled up since creation", reservedMb: "3221225472", code: codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{}
+			cfg.Server.AllocatableMemoryMb = 4096
+			server, docker := newTestServer(cfg, map[string]fakeResult{
+				"inspect --type container": {stdout: created},
+				"ps":                       {stdout: "running1\n"},
+				"inspect --format":         {stdout: tt.reservedMb + "\n"},
+			})
+
+			resp, err := server.StartCreatedTask(context.Background(), &StartCreatedTaskRequest{Name: "web"})
+			if status.Code(err) != tt.code {
+				t.Fatalf("StartCreatedTask error = %v, want code %v", err, tt.code)
+			}
+			if started := len(docker.commands("start")) > 0; started != tt.wantStarted {
+				t.Errorf("docker start ran = %v, want %v", started, tt.wantStarted)
+			}
+			if tt.code == codes.OK && (resp.Name != "web" || resp.ContainerId != "abc123") {
+				t.Errorf("response = %v, want name web and container abc123", resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBenchImage runs the built-in CPU benchmark script when no benchmark image is configured
+const DefaultBenchImage = "busybox"
+
+// cpuBenchScript counts shell loop iterations for BENCH_SECONDS and prints the rate
+const cpuBenchScript = `end=$(( $(date +%s) + BENCH_SECONDS )); n=0; ` +
+	`while [ $(date +%s) -lt $end ]; do n=$((n+1)); done; echo $((n / BENCH_SECONDS))`
+
+// BenchResult is the outcome of a benchmark run, reported in the heartbeat when saved
+type BenchResult struct {
+	CpuScore   float64 `json:"cpuScore"`
+	GpuScore   float64 `json:"gpuScore,omitempty"`
+	Seconds    int     `json:"seconds"`
+	FinishedAt string  `json:"finishedAt"`
+}
+
+// RunBenchmark launches the benchmark containers through StartTask and collects their scores.
+// A benchmark image gets BENCH_SECONDS in its environment and must print its score on the last line
+func (s *GrpcServer) RunBenchmark(ctx context.Context) (*BenchResult, error) {
+	seconds := s.Config.Task.BenchSeconds
+	if seconds <= 0 {
+		seconds = 10
+	}
+	result := &BenchResult{Seconds: seconds}
+
+	cpuRequest := &StartTaskRequest{
+		Name:  "bench-cpu",
+		Image: s.Config.Task.BenchImage,
+		Envs:  []string{fmt.Sprintf("BENCH_SECONDS=%d", seconds)},
+		Wait:  true,
+	}
+	if cpuRequest.Image == "" {
+		cpuRequest.Image = DefaultBenchImage
+		cpuRequest.Command = []string{"sh", "-c", strings.ReplaceAll(cpuBenchScript, "BENCH_SECONDS", strconv.Itoa(seconds))}
+	}
+	score, err := s.benchScore(ctx, cpuRequest)
+	if err != nil {
+		return nil, fmt.Errorf("CPU benchmark failed: %v", err)
+	}
+	result.CpuScore = score
+
+	if s.Config.Task.BenchGpuImage != "" {
+		gpus, err := collectGpus(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect GPUs: %v", err)
+		}
+		if len(gpus) > 0 {
+			gpuRequest := &StartTaskRequest{
+				Name:  "bench-gpu",
+				Image: s.Config.Task.BenchGpuImage,
+				Envs:  cpuRequest.Envs,
+				Wait:  true,
+			}
+			for _, gpu := range gpus {
+				gpuRequest.Gpus = append(gpuRequest.Gpus, gpu.Slot)
+			}
+			score, err := s.benchScore(ctx, gpuRequest)
+			if err != nil {
+				return nil, fmt.Errorf("GPU benchmark failed: %v", err)
+			}
+			result.GpuScore = score
+		}
+	}
+
+	result.FinishedAt = time.Now().Format(time.RFC3339)
+	return result, nil
+}
+
+// benchScore runs one benchmark container and parses the score from the last line of its output
+func (s *GrpcServer) benchScore(ctx context.Context, req *StartTaskRequest) (float64, error) {
+	response, err := s.StartTask(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	if response.ExitCode != 0 {
+		return 0, fmt.Errorf("exit code %d: %s", response.ExitCode, response.Output)
+	}
+	lines := strings.Split(strings.TrimSpace(response.Output), "\n")
+	lastLine := strings.TrimSpace(lines[len(lines)-1])
+	score, err := strconv.ParseFloat(lastLine, 64)
+	if err != nil {
+		return 0, fmt.Errorf("last output line is not a score: '%s'", lastLine)
+	}
+	return score, nil
+}
+
+// SaveBenchResult writes the result where the heartbeat picks it up
+func SaveBenchResult(fileName string, result *BenchResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, data, 0644)
+}
+
+// loadBenchResult reads a saved benchmark result, nil when there is none
+func loadBenchResult(fileName string) *BenchResult {
+	if fileName == "" {
+		return nil
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil
+	}
+	var result BenchResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil
+	}
+	return &result
+}
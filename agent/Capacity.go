@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pbnjay/memory"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MemoryLabel records the memory a managed container requested at launch, in MB. With GpuLabel it lets the
+// control plane compare what was requested with what is allocated after an agent restart
+const MemoryLabel = "cangling.memory-mb"
+
+// TaskResources are the resources a task requested at launch
+type TaskResources struct {
+	MemoryMb int32   `json:"memoryMb"`
+	Gpus     []int32 `json:"gpus,omitempty"`
+}
+
+// requestedMemoryMb reads MemoryLabel, 0 when the task set no memory limit
+func requestedMemoryMb(labels map[string]string) int32 {
+	memoryMb, _ := strconv.ParseInt(labels[MemoryLabel], 10, 32)
+	return int32(memoryMb)
+}
+
+// requestedGpus reads the GPU indices of GpuLabel
+func requestedGpus(labels map[string]string) []int32 {
+	var gpus []int32
+	for _, index := range strings.Split(labels[GpuLabel], ",") {
+		if slot, err := strconv.Atoi(strings.TrimSpace(index)); err == nil {
+			gpus = append(gpus, int32(slot))
+		}
+	}
+	return gpus
+}
+
+// taskRequests maps the task key of the running containers that requested memory or GPUs to their request
+func taskRequests(inspected []containerInspect) map[string]TaskResources {
+	requests := map[string]TaskResources{}
+	for _, container := range inspected {
+		if !container.State.Running {
+			continue
+		}
+		resources := TaskResources{
+			MemoryMb: requestedMemoryMb(container.Config.Labels),
+			Gpus:     requestedGpus(container.Config.Labels),
+		}
+		if resources.MemoryMb > 0 || len(resources.Gpus) > 0 {
+			requests[taskKey(container)] = resources
+		}
+	}
+	return requests
+}
+
+// allocatableMemoryMb is the memory tasks may reserve, the physical memory unless configured lower
+func allocatableMemoryMb(serverConfig config.ServerConfig) int64 {
+	if serverConfig.AllocatableMemoryMb > 0 {
+		return serverConfig.AllocatableMemoryMb
+	}
+	return int64(memory.TotalMemory() / 1024 / 1024)
+}
+
+// allocatableGpus is the number of GPUs tasks may reserve, all installed GPUs unless configured lower
+func allocatableGpus(ctx context.Context, serverConfig config.ServerConfig) int32 {
+	if serverConfig.AllocatableGpus > 0 {
+		return serverConfig.AllocatableGpus
+	}
+	gpus, err := collectGpus(ctx)
+	if err != nil {
+		return 0
+	}
+	return int32(len(gpus))
+}
+
+// reservedMemoryMb sums the memory limits of the running managed containers of this agent
+func (s *GrpcServer) reservedMemoryMb(ctx context.Context) (int64, error) {
+	output, err := s.dockerOutput(ctx, append([]string{"ps", "-q"}, s.managedFilters()...)...)
+	if err != nil {
+		return 0, err
+	}
+	ids := strings.Fields(output)
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	output, err = s.dockerOutput(ctx, append([]string{"inspect", "--format", "{{.HostConfig.Memory}}"}, ids...)...)
+	if err != nil {
+		return 0, err
+	}
+	var reserved int64
+	for _, line := range strings.Fields(output) {
+		limit, err := strconv.ParseInt(line, 10, 64)
+		if err == nil {
+			reserved += limit / 1024 / 1024
+		}
+	}
+	return reserved, nil
+}
+
+// checkCapacity refuses a task whose memory or GPUs would exceed the node's allocatable capacity
+func (s *GrpcServer) checkCapacity(ctx context.Context, req *StartTaskRequest) error {
+	if req.MemoryMb > 0 {
+		reserved, err := s.reservedMemoryMb(ctx)
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to compute reserved memory: %v", err)
+		}
+		allocatable := allocatableMemoryMb(s.Config.Server)
+		if reserved+int64(req.MemoryMb) > allocatable {
+			return reasonStatus(codes.ResourceExhausted, ReasonResourceExhausted, fmt.Sprintf(
+				"Not enough allocatable memory: %dMB of %dMB reserved, %dMB requested", reserved, allocatable, req.MemoryMb))
+		}
+	}
+	if len(req.Gpus) > 0 {
+		allocations, err := s.gpuAllocations(ctx)
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to query GPU allocations: %v", err)
+		}
+		allocatable := allocatableGpus(ctx, s.Config.Server)
+		if int32(len(allocations)+len(req.Gpus)) > allocatable {
+			return reasonStatus(codes.ResourceExhausted, ReasonResourceExhausted, fmt.Sprintf(
+				"Not enough allocatable GPUs: %d of %d in use, %d requested", len(allocations), allocatable, len(req.Gpus)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaxCopyMb bounds copied archives when maxCopyMb is not configured
+const DefaultMaxCopyMb = 1024
+
+var errCopyLimit = errors.New("copy size limit exceeded")
+
+// CopyFromTask streams a tar archive of a path in a container, as written by docker cp
+func (s *GrpcServer) CopyFromTask(req *CopyFromTaskRequest, stream AgentService_CopyFromTaskServer) error {
+	containerName, targetName, err := s.taskTarget(stream.Context(), req.Name, req.JobId)
+	if err != nil {
+		return err
+	}
+	containerPath, err := validateContainerPath(req.Path)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	writer := &copyChunkWriter{stream: stream, limit: s.maxCopyBytes(), cancel: cancel}
+	var dockerStderr bytes.Buffer
+	if err := s.Docker.Stream(ctx, writer, &dockerStderr, "cp", containerName+":"+containerPath, "-"); err != nil {
+		if writer.exceeded {
+			return status.Errorf(codes.ResourceExhausted, "Archive of '%s' exceeds the copy limit of %d bytes", containerPath, writer.limit)
+		}
+		if stream.Context().Err() != nil {
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+		return copyStatus(targetName, containerPath, dockerStderr.String())
+	}
+	return nil
+}
+
+// CopyToTask extracts the tar archive streamed by the client into a directory of a container
+func (s *GrpcServer) CopyToTask(stream AgentService_CopyToTaskServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	containerName, targetName, err := s.taskTarget(stream.Context(), first.Name, first.JobId)
+	if err != nil {
+		return err
+	}
+	containerPath, err := validateContainerPath(first.Path)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	command := s.Docker.Command(ctx, "cp", "-", containerName+":"+containerPath)
+	var dockerStderr bytes.Buffer
+	command.Stderr = &dockerStderr
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to open stdin: %v", err)
+	}
+	if err := command.Start(); err != nil {
+		return status.Errorf(codes.Internal, "Failed to start copy: %v", err)
+	}
+
+	limit := s.maxCopyBytes()
+	var copied int64
+	input := first
+	for {
+		copied += int64(len(input.Data))
+		if copied > limit {
+			cancel()
+			_ = command.Wait()
+			return status.Errorf(codes.ResourceExhausted, "Archive exceeds the copy limit of %d bytes", limit)
+		}
+		if _, err := stdin.Write(input.Data); err != nil {
+			// docker cp stopped reading, its exit status tells why
+			break
+		}
+		input, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cancel()
+			_ = command.Wait()
+			return err
+		}
+	}
+	_ = stdin.Close()
+	if err := command.Wait(); err != nil {
+		return copyStatus(targetName, containerPath, dockerStderr.String())
+	}
+	return stream.SendAndClose(&CopyToTaskResponse{
+		BytesCopied: copied,
+		Message:     fmt.Sprintf("Copied %d bytes to '%s' of container '%s'", copied, containerPath, targetName),
+	})
+}
+
+// validateContainerPath requires an absolute path and returns it cleaned
+func validateContainerPath(containerPath string) (string, error) {
+	if containerPath == "" {
+		return "", status.Error(codes.InvalidArgument, "Field 'path' is required")
+	}
+	if !path.IsAbs(containerPath) {
+		return "", status.Errorf(codes.InvalidArgument, "Path '%s' must be absolute", containerPath)
+	}
+	return path.Clean(containerPath), nil
+}
+
+// copyStatus builds the error of a failed docker cp
+func copyStatus(targetName string, containerPath string, stderr string) error {
+	stderr = strings.TrimSpace(stderr)
+	if strings.Contains(stderr, "Could not find the file") {
+		return status.Errorf(codes.NotFound, "Path '%s' does not exist in container '%s'", containerPath, targetName)
+	}
+	return dockerStatus(fmt.Sprintf("Failed to copy '%s' of container '%s': %s", containerPath, targetName, stderr), stderr)
+}
+
+func (s *GrpcServer) maxCopyBytes() int64 {
+	limit := s.Config.Task.MaxCopyMb
+	if limit <= 0 {
+		limit = DefaultMaxCopyMb
+	}
+	return limit * 1024 * 1024
+}
+
+// copyChunkWriter sends docker cp output as file chunks, canceling the copy once the limit is passed
+type copyChunkWriter struct {
+	stream   AgentService_CopyFromTaskServer
+	limit    int64
+	written  int64
+	exceeded bool
+	cancel   context.CancelFunc
+}
+
+func (w *copyChunkWriter) Write(p []byte) (int, error) {
+	if w.written+int64(len(p)) > w.limit {
+		w.exceeded = true
+		w.cancel()
+		return 0, errCopyLimit
+	}
+	data := make([]byte, len(p))
+	copy(data, p)
+	if err := w.stream.Send(&FileChunk{Data: data}); err != nil {
+		return 0, err
+	}
+	w.written += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CordonSource names who cordoned the node, which stays cordoned while any source holds its cordon
+type CordonSource uint32
+
+const (
+	// CordonOperator is the Cordon command and the cordoned flag of the config
+	CordonOperator CordonSource = 1 << iota
+	// CordonWatchdog is the ReportWatchdog cordoning a node cut off from the control plane
+	CordonWatchdog
+)
+
+// cordonSources is shared by StartTask and the heartbeat, the operator's bit is initialised from the config at startup
+var cordonSources atomic.Uint32
+
+// configMutex serialises config writes made while the agent is running
+var configMutex sync.Mutex
+
+// SetCordoned sets or lifts the cordon of source, leaving the cordons of the other sources in place
+func SetCordoned(source CordonSource, value bool) {
+	if value {
+		cordonSources.Or(uint32(source))
+	} else {
+		cordonSources.And(^uint32(source))
+	}
+}
+
+// IsCordoned reports whether the node refuses new tasks
+func IsCordoned() bool {
+	return cordonSources.Load() != 0
+}
+
+// Cordon stops the node from accepting new tasks, running tasks are left untouched
+func (s *GrpcServer) Cordon(ctx context.Context, req *Empty) (*CordonResponse, error) {
+	return s.setCordoned(true)
+}
+
+// Uncordon lets the node accept new tasks again
+func (s *GrpcServer) Uncordon(ctx context.Context, req *Empty) (*CordonResponse, error) {
+	return s.setCordoned(false)
+}
+
+func (s *GrpcServer) setCordoned(value bool) (*CordonResponse, error) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	source := CordonOperator
+	if !value {
+		// An operator's uncordon also lifts the watchdog's cordon
+		source |= CordonWatchdog
+	}
+	SetCordoned(source, value)
+	s.Config.Server.Cordoned = value
+	// Persist so the state survives an agent restart
+	if err := s.Config.Save(""); err != nil {
+		return nil, status.Errorf(codes.Internal, "Node state changed but could not be saved: %v", err)
+	}
+	message := "Node is schedulable"
+	if value {
+		message = "Node is cordoned, new tasks are refused"
+	}
+	return &CordonResponse{Schedulable: !value, Message: message}, nil
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCordonKeepsRegistration(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(fileName, []byte("[server]\nregisterToken = \"once\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := config.ResolveConfig(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := newTestServer(cfg, nil)
+	t.Cleanup(func() { SetCordoned(CordonOperator, false) })
+
+	if err := server.SaveRegistration("node-1", "https://cangling.example.com", 1700000000000); err != nil {
+		t.Fatalf("SaveRegistration: %v", err)
+	}
+	if _, err := server.Cordon(context.Background(), &Empty{}); err != nil {
+		t.Fatalf("Cordon: %v", err)
+	}
+
+	saved, _, err := config.ResolveConfig(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Server.AgentId != "node-1" || saved.Server.RegisterToken != "" || !saved.Server.Cordoned {
+		t.Errorf("saved server config = %+v, want the registration kept, the token dropped and the node cordoned", saved.Server)
+	}
+}
+
+func TestWatchdogKeepsOperatorCordon(t *testing.T) {
+	tests := []struct {
+		name         string
+		operator     bool
+		wantCordoned bool
+	}{
+		{name: "watchdog cordon lifted on recovery"},
+		{name: "operator cordon kept on recovery", operator: true, wantCordoned: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { SetCordoned(CordonOperator|CordonWatchdog, false) })
+			watchdog, err := NewReportWatchdog(config.ServerConfig{ReportFailureThreshold: 1, ReportFailureAction: ReportFailureCordon})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			watchdog.Failure(errors.New("unreachable"))
+			if tt.operator {
+				SetCordoned(CordonOperator, true)
+			}
+			if !IsCordoned() {
+				t.Fatalf("node not cordoned after the heartbeat failed")
+			}
+			watchdog.Success()
+			if IsCordoned() != tt.wantCordoned {
+				t.Errorf("IsCordoned() after recovery = %v, want %v", IsCordoned(), tt.wantCordoned)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// changeKinds maps the docker diff prefixes to change kinds
+var changeKinds = map[string]ChangeKind{
+	"A": ChangeKind_CHANGE_ADDED,
+	"C": ChangeKind_CHANGE_MODIFIED,
+	"D": ChangeKind_CHANGE_DELETED,
+}
+
+// DiffTask lists the paths a container added, changed or deleted relative to its image
+func (s *GrpcServer) DiffTask(ctx context.Context, req *DiffTaskRequest) (*DiffTaskResponse, error) {
+	containerName, targetName, err := s.taskTarget(ctx, req.Name, req.JobId)
+	if err != nil {
+		return nil, err
+	}
+	output, err := s.dockerOutput(ctx, "diff", containerName)
+	if err != nil {
+		if strings.Contains(err.Error(), "No such") {
+			return nil, status.Errorf(codes.NotFound, "Container '%s' does not exist", targetName)
+		}
+		return nil, status.Errorf(codes.Internal, "Failed to diff task: %v", err)
+	}
+	return &DiffTaskResponse{Changes: parseDiff(output)}, nil
+}
+
+// parseDiff parses `docker diff` lines such as "C /etc" into file changes
+func parseDiff(output string) []*FileChange {
+	var changes []*FileChange
+	for _, line := range strings.Split(output, "\n") {
+		prefix, path, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		changes = append(changes, &FileChange{Kind: changeKinds[prefix], Path: path})
+	}
+	return changes
+}
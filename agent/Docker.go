@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DockerRunner runs docker CLI commands. GrpcServer takes one so its handlers can run against a fake docker
+type DockerRunner interface {
+	// Run runs docker to completion and returns its stdout and stderr
+	Run(ctx context.Context, args ...string) (string, string, error)
+	// Stream runs docker, copying its output to the writers as it arrives, until it exits or ctx is done
+	Stream(ctx context.Context, stdout io.Writer, stderr io.Writer, args ...string) error
+	// Command prepares a docker command for callers that need its stdin or a terminal
+	Command(ctx context.Context, args ...string) *exec.Cmd
+}
+
+// ExecDocker runs the docker binary found in PATH
+type ExecDocker struct{}
+
+func (ExecDocker) Run(ctx context.Context, args ...string) (string, string, error) {
+	command := exec.CommandContext(ctx, "docker", args...)
+	var commandOutput bytes.Buffer
+	var commandError bytes.Buffer
+	command.Stdout = &commandOutput
+	command.Stderr = &commandError
+	err := command.Run()
+	return commandOutput.String(), commandError.String(), err
+}
+
+func (ExecDocker) Stream(ctx context.Context, stdout io.Writer, stderr io.Writer, args ...string) error {
+	command := exec.CommandContext(ctx, "docker", args...)
+	command.Stdout = stdout
+	command.Stderr = stderr
+	return command.Run()
+}
+
+func (ExecDocker) Command(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "docker", args...)
+}
+
+// TimeoutDocker bounds the Run and Stream commands of another runner by the configured timeout of their kind,
+// so a slow pull is given minutes while a hung docker ps fails fast. Followed logs, copies, exec and wait stay unbounded
+type TimeoutDocker struct {
+	Runner   DockerRunner
+	Timeouts config.DockerTimeouts
+}
+
+func (t TimeoutDocker) Run(ctx context.Context, args ...string) (string, string, error) {
+	timeout := t.timeout(args)
+	if timeout <= 0 {
+		return t.Runner.Run(ctx, args...)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	stdout, stderr, err := t.Runner.Run(ctx, args...)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	return stdout, stderr, err
+}
+
+func (t TimeoutDocker) Stream(ctx context.Context, stdout io.Writer, stderr io.Writer, args ...string) error {
+	timeout := t.timeout(args)
+	if timeout <= 0 {
+		return t.Runner.Stream(ctx, stdout, stderr, args...)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := t.Runner.Stream(ctx, stdout, stderr, args...)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	return err
+}
+
+func (t TimeoutDocker) Command(ctx context.Context, args ...string) *exec.Cmd {
+	return t.Runner.Command(ctx, args...)
+}
+
+// timeout returns the bound of a docker command, 0 for the commands that are not bounded
+func (t TimeoutDocker) timeout(args []string) time.Duration {
+	if len(args) == 0 {
+		return 0
+	}
+	var seconds, defaultSeconds int
+	switch dockerSubcommand(args) {
+	case "pull", "image pull":
+		seconds, defaultSeconds = t.Timeouts.Pull, 1800
+	case "run", "create", "start", "restart":
+		seconds, defaultSeconds = t.Timeouts.Run, 600
+	case "stop", "kill", "rm", "container prune", "image prune", "volume prune":
+		seconds, defaultSeconds = t.Timeouts.Stop, 120
+	case "ps", "ls", "list", "images", "stats", "image ls", "network ls", "volume ls":
+		seconds, defaultSeconds = t.Timeouts.List, 30
+	case "inspect", "diff", "update", "info", "version",
+		"image inspect", "network inspect", "volume inspect":
+		seconds, defaultSeconds = t.Timeouts.Inspect, 30
+	default:
+		return 0
+	}
+	if seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// dockerSubcommand names the command of a docker argv. Commands of the container group are named like
+// their top level form (container stop is stop), those of the other groups keep the group (image inspect)
+func dockerSubcommand(args []string) string {
+	if len(args) < 2 {
+		return args[0]
+	}
+	switch args[0] {
+	case "container":
+		if args[1] == "prune" {
+			return "container prune"
+		}
+		return args[1]
+	case "image", "network", "volume":
+		return args[0] + " " + args[1]
+	}
+	return args[0]
+}
+
+// defaultDocker serves the background work not tied to a request, such as the heartbeat and the janitor
+var defaultDocker DockerRunner = ExecDocker{}
+
+// dockerOutput runs a docker command and returns its stdout, folding stderr into the error
+func dockerOutput(ctx context.Context, args ...string) (string, error) {
+	return runnerOutput(ctx, defaultDocker, args...)
+}
+
+// dockerOutput runs a docker command with the server's runner, see the dockerOutput function
+func (s *GrpcServer) dockerOutput(ctx context.Context, args ...string) (string, error) {
+	return runnerOutput(ctx, s.Docker, args...)
+}
+
+func runnerOutput(ctx context.Context, docker DockerRunner, args ...string) (string, error) {
+	stdout, stderr, err := docker.Run(ctx, args...)
+	if err != nil {
+		errMsg := fmt.Sprintf("docker %s failed: %v", args[0], err)
+		if stderr != "" {
+			errMsg += fmt.Sprintf(" | Docker STDERR: %s", strings.TrimSpace(stderr))
+		}
+		return "", fmt.Errorf("%s", errMsg)
+	}
+	return stdout, nil
+}
+
+// DefaultMaxOutputBytes bounds docker output copied into responses when maxOutputBytes is not configured
+const DefaultMaxOutputBytes = 256 * 1024
+
+// truncateOutput cuts docker output copied into a response to the configured size, marking what was dropped
+func (s *GrpcServer) truncateOutput(output string) string {
+	limit := s.Config.Task.MaxOutputBytes
+	if limit <= 0 {
+		limit = DefaultMaxOutputBytes
+	}
+	if len(output) <= limit {
+		return output
+	}
+	// Cut on a rune boundary, protobuf strings must stay valid UTF-8
+	for limit > 0 && !utf8.RuneStart(output[limit]) {
+		limit--
+	}
+	return fmt.Sprintf("%s\n... [truncated %d bytes]", output[:limit], len(output)-limit)
+}
@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResult is what fakeDocker answers to one docker command
+type fakeResult struct {
+	stdout string
+	stderr string
+	err    error
+}
+
+// fakeDocker records the argv of every docker command and answers them from results,
+// keyed by the longest space-joined argv prefix that matches, so "inspect" and "inspect --format" can differ
+type fakeDocker struct {
+	mu      sync.Mutex
+	calls   [][]string
+	results map[string]fakeResult
+}
+
+func (f *fakeDocker) Run(ctx context.Context, args ...string) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, append([]string(nil), args...))
+	for n := len(args); n > 0; n-- {
+		if result, ok := f.results[strings.Join(args[:n], " ")]; ok {
+			return result.stdout, result.stderr, result.err
+		}
+	}
+	return "", "", nil
+}
+
+func (f *fakeDocker) Stream(ctx context.Context, stdout io.Writer, stderr io.Writer, args ...string) error {
+	out, errOut, err := f.Run(ctx, args...)
+	io.WriteString(stdout, out)
+	io.WriteString(stderr, errOut)
+	return err
+}
+
+func (f *fakeDocker) Command(ctx context.Context, args ...string) *exec.Cmd {
+	f.mu.Lock()
+	f.calls = append(f.calls, append([]string(nil), args...))
+	f.mu.Unlock()
+	return exec.CommandContext(ctx, "true")
+}
+
+// commands returns the recorded argv whose subcommand is name
+func (f *fakeDocker) commands(name string) [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matched [][]string
+	for _, call := range f.calls {
+		if len(call) > 0 && call[0] == name {
+			matched = append(matched, call)
+		}
+	}
+	return matched
+}
+
+// errExit stands in for the error of a docker command that exited non-zero
+var errExit = errors.New("exit status 1")
+
+// newTestServer returns a server running its docker commands against a fresh fakeDocker
+func newTestServer(cfg config.Config, results map[string]fakeResult) (*GrpcServer, *fakeDocker) {
+	docker := &fakeDocker{results: results}
+	return &GrpcServer{Version: "test", Config: cfg, Docker: docker}, docker
+}
+
+func TestStopTaskRunsDockerStop(t *testing.T) {
+	cfg := config.Config{}
+	cfg.Task.NamePrefix = "team-"
+	server, docker := newTestServer(cfg, map[string]fakeResult{
+		"inspect": {stdout: "false\n"},
+	})
+
+	resp, err := server.StopTask(context.Background(), &StopTaskRequest{Name: "web"})
+	if err != nil {
+		t.Fatalf("StopTask: %v", err)
+	}
+	if resp.Method != "stop" {
+		t.Errorf("Method = %q, want stop", resp.Method)
+	}
+	want := [][]string{{"stop", "team-web"}}
+	if got := docker.commands("stop"); !reflect.DeepEqual(got, want) {
+		t.Errorf("docker stop argv = %q, want %q", got, want)
+	}
+	if got := docker.commands("kill"); len(got) != 0 {
+		t.Errorf("unexpected docker kill: %q", got)
+	}
+}
+
+func TestStopTaskMissingContainer(t *testing.T) {
+	server, _ := newTestServer(config.Config{}, map[string]fakeResult{
+		"stop": {stderr: "Error response from daemon: No such container: web", err: errExit},
+	})
+
+	resp, err := server.StopTask(context.Background(), &StopTaskRequest{Name: "web"})
+	if err != nil {
+		t.Fatalf("StopTask: %v", err)
+	}
+	if resp.Method != "none" {
+		t.Errorf("Method = %q, want none", resp.Method)
+	}
+}
+
+func TestKillTaskByJobId(t *testing.T) {
+	server, docker := newTestServer(config.Config{}, map[string]fakeResult{
+		"ps": {stdout: "abc123\n"},
+	})
+
+	resp, err := server.KillTask(context.Background(), &KillTaskRequest{JobId: "job-1", Signal: "hup"})
+	if err != nil {
+		t.Fatalf("KillTask: %v", err)
+	}
+	want := [][]string{{"kill", "--signal", "HUP", "abc123"}}
+	if got := docker.commands("kill"); !reflect.DeepEqual(got, want) {
+		t.Errorf("docker kill argv = %q, want %q", got, want)
+	}
+	if !strings.Contains(resp.Message, "job-1") {
+		t.Errorf("Message = %q, want it to name job-1", resp.Message)
+	}
+}
+
+func TestTimeoutDockerTimeout(t *testing.T) {
+	docker := TimeoutDocker{Timeouts: config.DockerTimeouts{Pull: 3600}}
+	tests := []struct {
+		args []string
+		want time.Duration
+	}{
+		{[]string{"pull", "nginx"}, time.Hour},
+		{[]string{"image", "pull", "nginx"}, time.Hour},
+		{[]string{"run", "-d", "nginx"}, 600 * time.Second},
+		{[]string{"container", "start", "web"}, 600 * time.Second},
+		{[]string{"container", "stop", "web"}, 120 * time.Second},
+		{[]string{"volume", "prune", "--force"}, 120 * time.Second},
+		{[]string{"ps", "-a"}, 30 * time.Second},
+		{[]string{"container", "ls"}, 30 * time.Second},
+		{[]string{"network", "ls"}, 30 * time.Second},
+		{[]string{"image", "inspect", "nginx"}, 30 * time.Second},
+		{[]string{"update", "--memory", "1g", "web"}, 30 * time.Second},
+		{[]string{"logs", "-f", "web"}, 0},
+		{[]string{"container", "logs", "web"}, 0},
+		{[]string{"cp", "web:/data", "-"}, 0},
+		{[]string{"wait", "web"}, 0},
+	}
+	for _, tt := range tests {
+		if got := docker.timeout(tt.args); got != tt.want {
+			t.Errorf("timeout(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+// blockingDocker streams until its context is done, like a hung docker daemon
+type blockingDocker struct{ fakeDocker }
+
+func (b *blockingDocker) Stream(ctx context.Context, stdout io.Writer, stderr io.Writer, args ...string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestTimeoutDockerStream(t *testing.T) {
+	docker := TimeoutDocker{Runner: &blockingDocker{}, Timeouts: config.DockerTimeouts{List: 1}}
+
+	err := docker.Stream(context.Background(), io.Discard, io.Discard, "ps", "-a")
+	if err == nil || err.Error() != "timed out after 1s" {
+		t.Errorf("Stream of a hung docker ps = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the ErrorInfo domain of the reasons below
+const ErrorDomain = "agent.cangling.cn"
+
+// Stable error reasons attached to failed calls as google.rpc.ErrorInfo details,
+// so clients can branch on the kind of failure instead of parsing messages
+const (
+	ReasonInvalidImage       = "INVALID_IMAGE"
+	ReasonContainerNotFound  = "CONTAINER_NOT_FOUND"
+	ReasonDockerUnavailable  = "DOCKER_UNAVAILABLE"
+	ReasonResourceExhausted  = "RESOURCE_EXHAUSTED"
+	ReasonNameConflict       = "NAME_CONFLICT"
+	ReasonDockerCommandError = "DOCKER_ERROR"
+)
+
+// dockerFailures maps docker stderr fragments to a status code and reason, first match wins
+var dockerFailures = []struct {
+	fragment string
+	code     codes.Code
+	reason   string
+}{
+	{"Cannot connect to the Docker daemon", codes.Unavailable, ReasonDockerUnavailable},
+	{"Is the docker daemon running", codes.Unavailable, ReasonDockerUnavailable},
+	{"executable file not found", codes.Unavailable, ReasonDockerUnavailable},
+	{"No such container", codes.NotFound, ReasonContainerNotFound},
+	{"invalid reference format", codes.InvalidArgument, ReasonInvalidImage},
+	{"pull access denied", codes.NotFound, ReasonInvalidImage},
+	{"manifest unknown", codes.NotFound, ReasonInvalidImage},
+	{"No such image", codes.NotFound, ReasonInvalidImage},
+	{"is already in use", codes.AlreadyExists, ReasonNameConflict},
+	{"no space left on device", codes.ResourceExhausted, ReasonResourceExhausted},
+	{"cannot allocate memory", codes.ResourceExhausted, ReasonResourceExhausted},
+	{"toomanyrequests", codes.ResourceExhausted, ReasonResourceExhausted},
+}
+
+// dockerStatus builds the gRPC error of a failed docker command, classified by its stderr
+func dockerStatus(message string, stderr string) error {
+	code, reason := codes.Internal, ReasonDockerCommandError
+	for _, failure := range dockerFailures {
+		if strings.Contains(stderr, failure.fragment) {
+			code, reason = failure.code, failure.reason
+			break
+		}
+	}
+	return reasonStatus(code, reason, message)
+}
+
+// reasonStatus builds a gRPC error carrying a stable reason
+func reasonStatus(code codes.Code, reason string, message string) error {
+	st := status.New(code, message)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
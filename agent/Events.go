@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// dockerEvent is the subset of `docker events --format "{{json .}}"` the agent forwards
+type dockerEvent struct {
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+var eventTypes = map[string]EventType{
+	"start": EventType_EVENT_START,
+	"die":   EventType_EVENT_DIE,
+	"stop":  EventType_EVENT_STOP,
+	"oom":   EventType_EVENT_OOM,
+}
+
+// WatchEvents streams lifecycle events of managed containers until the client disconnects
+func (s *GrpcServer) WatchEvents(req *Empty, stream AgentService_WatchEventsServer) error {
+	args := []string{"events", "--format", "{{json .}}", "--filter", "type=container"}
+	args = append(args, s.managedFilters()...)
+	for action := range eventTypes {
+		args = append(args, "--filter", "event="+action)
+	}
+	// The docker process is killed when the client cancels the stream
+	cmd := s.Docker.Command(stream.Context(), args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to watch events: %v", err)
+	}
+	var dockerStderr bytes.Buffer
+	cmd.Stderr = &dockerStderr
+	if err := cmd.Start(); err != nil {
+		return status.Errorf(codes.Internal, "Failed to watch events: %v", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var raw dockerEvent
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			logf(stream.Context(), "Skipping unparsable docker event: %v", err)
+			continue
+		}
+		event := toEvent(raw)
+		event.Name = s.clientName(event.Name)
+		if err := stream.Send(event); err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return err
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if stream.Context().Err() != nil {
+			logf(stream.Context(), "Client disconnected from event stream")
+			return nil
+		}
+		errMsg := fmt.Sprintf("Docker events failed: %v", err)
+		if dockerStderr.Len() > 0 {
+			errMsg += fmt.Sprintf(" | Docker STDERR: %s", dockerStderr.String())
+		}
+		return status.Error(codes.Internal, errMsg)
+	}
+	return nil
+}
+
+func toEvent(raw dockerEvent) *Event {
+	event := &Event{
+		Type:        eventTypes[raw.Action],
+		ContainerId: raw.Actor.ID,
+		Name:        raw.Actor.Attributes["name"],
+		Image:       raw.Actor.Attributes["image"],
+		JobId:       raw.Actor.Attributes["job-id"],
+		Time:        raw.TimeNano / 1e6,
+	}
+	if exitCode, err := strconv.Atoi(raw.Actor.Attributes["exitCode"]); err == nil {
+		event.ExitCode = int32(exitCode)
+	}
+	return event
+}
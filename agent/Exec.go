@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/creack/pty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExecInteractive runs a command in a container with docker exec -i, streaming stdin from the client
+// and stdout/stderr back. The first message names the container and command, later ones only carry input
+func (s *GrpcServer) ExecInteractive(stream AgentService_ExecInteractiveServer) error {
+	if !s.Config.Task.AllowExec {
+		return status.Error(codes.FailedPrecondition, "Exec into tasks is not allowed on this agent")
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	containerName, _, err := s.taskTarget(stream.Context(), first.Name, first.JobId)
+	if err != nil {
+		return err
+	}
+	if len(first.Command) == 0 {
+		return status.Error(codes.InvalidArgument, "Field 'command' is required")
+	}
+
+	args := []string{"exec", "-i"}
+	if first.Tty {
+		args = append(args, "-t")
+	}
+	args = append(append(args, containerName), first.Command...)
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	command := s.Docker.Command(ctx, args...)
+
+	output := &execOutputWriter{stream: stream}
+	var stdin io.WriteCloser
+	outputDone := make(chan struct{})
+	if first.Tty {
+		// A terminal merges stdout and stderr, everything comes back as stdout
+		terminal, err := pty.Start(command)
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to start exec: %v", err)
+		}
+		defer terminal.Close()
+		stdin = &terminalInput{terminal}
+		go func() {
+			// Reading fails with EIO once the command exits
+			_, _ = io.Copy(output.stdout(), terminal)
+			close(outputDone)
+		}()
+	} else {
+		stdin, err = command.StdinPipe()
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to open stdin: %v", err)
+		}
+		command.Stdout = output.stdout()
+		command.Stderr = output.stderr()
+		if err := command.Start(); err != nil {
+			return status.Errorf(codes.Internal, "Failed to start exec: %v", err)
+		}
+		close(outputDone)
+	}
+
+	go func() {
+		defer stdin.Close()
+		input := first
+		for {
+			if terminal, ok := stdin.(*terminalInput); ok {
+				resizeTerminal(terminal.File, input)
+			}
+			if len(input.Stdin) > 0 {
+				if _, err := stdin.Write(input.Stdin); err != nil {
+					return
+				}
+			}
+			if input.CloseStdin {
+				return
+			}
+			if input, err = stream.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = command.Wait()
+	<-outputDone
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "Exec failed: %v", err)
+	}
+	return output.send(&ExecOutput{Exited: true, ExitCode: int32(exitCode)})
+}
+
+// resizeTerminal applies the terminal size carried by a message, if any
+func resizeTerminal(terminal *os.File, input *ExecInput) {
+	if input.Rows > 0 && input.Cols > 0 {
+		_ = pty.Setsize(terminal, &pty.Winsize{Rows: uint16(input.Rows), Cols: uint16(input.Cols)})
+	}
+}
+
+// terminalInput closes a terminal's input by sending end-of-transmission, closing the terminal would hang up the command
+type terminalInput struct {
+	*os.File
+}
+
+func (t *terminalInput) Close() error {
+	_, err := t.Write([]byte{4})
+	return err
+}
+
+// execOutputWriter serializes the stdout and stderr chunks onto the stream
+type execOutputWriter struct {
+	mutex  sync.Mutex
+	stream AgentService_ExecInteractiveServer
+}
+
+func (w *execOutputWriter) send(output *ExecOutput) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.stream.Send(output)
+}
+
+func (w *execOutputWriter) stdout() io.Writer {
+	return execStreamWriter(func(data []byte) error { return w.send(&ExecOutput{Stdout: data}) })
+}
+
+func (w *execOutputWriter) stderr() io.Writer {
+	return execStreamWriter(func(data []byte) error { return w.send(&ExecOutput{Stderr: data}) })
+}
+
+type execStreamWriter func(data []byte) error
+
+func (f execStreamWriter) Write(data []byte) (int, error) {
+	// The caller reuses its buffer, so the chunk needs its own copy
+	if err := f(append([]byte(nil), data...)); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
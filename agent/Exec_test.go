@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// refusedExecStream fails the test when ExecInteractive reads a request it should have refused first
+type refusedExecStream struct {
+	AgentService_ExecInteractiveServer
+	t *testing.T
+}
+
+func (r refusedExecStream) Recv() (*ExecInput, error) {
+	r.t.Fatal("ExecInteractive read the request before checking allowExec")
+	return nil, nil
+}
+
+func TestExecInteractiveNotAllowed(t *testing.T) {
+	server, docker := newTestServer(config.Config{}, nil)
+
+	err := server.ExecInteractive(refusedExecStream{t: t})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ExecInteractive = %v, want FailedPrecondition", err)
+	}
+	if len(docker.calls) != 0 {
+		t.Errorf("unexpected docker commands: %q", docker.calls)
+	}
+}
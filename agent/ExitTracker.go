@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPendingExits bounds the exits kept while the control plane is unreachable, the oldest are dropped first
+const maxPendingExits = 1000
+
+// ExitedTask describes a managed container that finished, reported until the server acknowledges it
+type ExitedTask struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	JobId      string `json:"jobId"`
+	ExitCode   int32  `json:"exitCode"`
+	FinishedAt int64  `json:"finishedAt"`
+	// seq tells apart two exits of the same container, as a restarted container keeps its id
+	seq uint64
+}
+
+type exitTracker struct {
+	mutex   sync.Mutex
+	pending []ExitedTask
+	lastSeq uint64
+}
+
+var exitedTasks = &exitTracker{}
+
+func (t *exitTracker) record(task ExitedTask) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lastSeq++
+	task.seq = t.lastSeq
+	t.pending = append(t.pending, task)
+	if len(t.pending) > maxPendingExits {
+		t.pending = t.pending[len(t.pending)-maxPendingExits:]
+	}
+}
+
+// list returns a snapshot of the exits not yet acknowledged
+func (t *exitTracker) list() []ExitedTask {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]ExitedTask(nil), t.pending...)
+}
+
+// acknowledge drops the reported exits, those recorded after the list was taken stay pending
+func (t *exitTracker) acknowledge(reported []ExitedTask) {
+	if len(reported) == 0 {
+		return
+	}
+	acknowledged := make(map[uint64]bool, len(reported))
+	for _, task := range reported {
+		acknowledged[task.seq] = true
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	remaining := t.pending[:0]
+	for _, task := range t.pending {
+		if !acknowledged[task.seq] {
+			remaining = append(remaining, task)
+		}
+	}
+	t.pending = remaining
+}
+
+// WatchExits records the exit of every managed container of the agent using namePrefix until ctx is done.
+// Events are used rather than polling since auto-removed containers vanish as soon as they exit.
+func WatchExits(ctx context.Context, namePrefix string) {
+	for ctx.Err() == nil {
+		if err := watchExitEvents(ctx, namePrefix); err != nil && ctx.Err() == nil {
+			log.Printf("Watching container exits failed, retrying: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func watchExitEvents(ctx context.Context, namePrefix string) error {
+	args := []string{"events", "--format", "{{json .}}", "--filter", "type=container", "--filter", "event=die"}
+	cmd := defaultDocker.Command(ctx, append(args, managedFiltersFor(namePrefix)...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var raw dockerEvent
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			continue
+		}
+		event := toEvent(raw)
+		exitedTasks.record(ExitedTask{
+			Id:         event.ContainerId,
+			Name:       strings.TrimPrefix(event.Name, namePrefix),
+			JobId:      event.JobId,
+			ExitCode:   event.ExitCode,
+			FinishedAt: event.Time,
+		})
+	}
+	return cmd.Wait()
+}
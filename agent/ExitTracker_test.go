@@ -0,0 +1,22 @@
+package agent
+
+import "testing"
+
+func TestAcknowledgeKeepsLaterExitOfSameContainer(t *testing.T) {
+	tracker := &exitTracker{}
+	tracker.record(ExitedTask{Id: "abc123", ExitCode: 1, FinishedAt: 100})
+	reported := tracker.list()
+
+	// The container restarts and dies again while the report is in flight
+	tracker.record(ExitedTask{Id: "abc123", ExitCode: 137, FinishedAt: 100})
+	tracker.acknowledge(reported)
+
+	pending := tracker.list()
+	if len(pending) != 1 || pending[0].ExitCode != 137 {
+		t.Fatalf("pending after acknowledge = %+v, want the second exit only", pending)
+	}
+	tracker.acknowledge(pending)
+	if pending = tracker.list(); len(pending) != 0 {
+		t.Errorf("pending after second acknowledge = %+v, want none", pending)
+	}
+}
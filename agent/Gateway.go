@@ -0,0 +1,345 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// gatewayRoutes keeps the /api/v1 paths of the old HTTP API, each served by the gRPC method of the same name
+var gatewayRoutes = []struct {
+	path       string
+	httpMethod string
+	rpcMethod  string
+}{
+	{"/api/v1/node/info", http.MethodGet, "GetVersion"},
+	{"/api/v1/task/ls", http.MethodGet, "ListTasks"},
+	{"/api/v1/task/start", http.MethodPost, "StartTask"},
+	{"/api/v1/task/stop", http.MethodGet, "StopTask"},
+}
+
+// readOnlyMethods are the unary methods /api/v1/rpc/{method} also serves over GET, the others need POST
+var readOnlyMethods = map[string]bool{
+	"GetVersion": true, "ListTasks": true, "InspectTask": true, "InspectTasks": true,
+	"GetNodeInfo": true, "ListGpus": true, "GetConfig": true, "DiffTask": true,
+}
+
+// Gateway serves the AgentService over REST/JSON by calling the gRPC handlers in process,
+// so both transports share one implementation and the same interceptors
+type Gateway struct {
+	server            AgentServiceServer
+	interceptor       grpc.UnaryServerInterceptor
+	streamInterceptor grpc.StreamServerInterceptor
+	methods           map[string]grpc.MethodDesc
+	// maxBodyBytes bounds request bodies, larger ones are refused with 413
+	maxBodyBytes int64
+}
+
+// NewGateway builds the REST handler. Every unary method is available as POST /api/v1/rpc/{method}
+// with the JSON form of its request message, and the read-only ones also as GET with the request fields
+// as query parameters. Request bodies larger than maxBodyBytes are refused
+func NewGateway(server AgentServiceServer, maxBodyBytes int, interceptors []grpc.UnaryServerInterceptor, streamInterceptors []grpc.StreamServerInterceptor) http.Handler {
+	g := &Gateway{
+		server:            server,
+		interceptor:       chainUnaryInterceptors(interceptors),
+		streamInterceptor: chainStreamInterceptors(streamInterceptors),
+		methods:           map[string]grpc.MethodDesc{},
+		maxBodyBytes:      int64(maxBodyBytes),
+	}
+	for _, method := range AgentService_ServiceDesc.Methods {
+		g.methods[method.MethodName] = method
+	}
+
+	router := mux.NewRouter()
+	for _, route := range gatewayRoutes {
+		rpcMethod := route.rpcMethod
+		router.HandleFunc(route.path, func(w http.ResponseWriter, r *http.Request) {
+			g.callUnary(w, r, rpcMethod)
+		}).Methods(route.httpMethod)
+	}
+	router.HandleFunc("/api/v1/task/log", g.streamLogs).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/rpc/{method}", func(w http.ResponseWriter, r *http.Request) {
+		rpcMethod := mux.Vars(r)["method"]
+		if r.Method == http.MethodGet && !readOnlyMethods[rpcMethod] {
+			w.Header().Set("Allow", http.MethodPost)
+			writeGatewayStatus(w, http.StatusMethodNotAllowed,
+				status.Newf(codes.InvalidArgument, "Method '%s' changes the node and needs POST", rpcMethod))
+			return
+		}
+		g.callUnary(w, r, rpcMethod)
+	}).Methods(http.MethodGet, http.MethodPost)
+	return router
+}
+
+// callUnary decodes the request, runs the gRPC handler through the interceptors and writes the JSON response
+func (g *Gateway) callUnary(w http.ResponseWriter, r *http.Request, rpcMethod string) {
+	method, ok := g.methods[rpcMethod]
+	if !ok {
+		writeGatewayError(w, status.Errorf(codes.Unimplemented, "Unknown method '%s'", rpcMethod))
+		return
+	}
+	httpRequestId(w, r)
+	decode := func(request any) error {
+		message := request.(proto.Message)
+		body, err := g.requestBody(w, r, message.ProtoReflect().Descriptor())
+		if err != nil {
+			return err
+		}
+		if len(body) == 0 {
+			return nil
+		}
+		if err := protojson.Unmarshal(body, message); err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid request: %v", err)
+		}
+		return nil
+	}
+
+	response, err := method.Handler(g.server, gatewayContext(r), decode, g.interceptor)
+	if err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	data, err := protojson.Marshal(response.(proto.Message))
+	if err != nil {
+		writeGatewayError(w, status.Errorf(codes.Internal, "Failed to encode response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// streamLogs follows the container logs as a chunked plain text response
+func (g *Gateway) streamLogs(w http.ResponseWriter, r *http.Request) {
+	httpRequestId(w, r)
+	request := &StreamLogsRequest{}
+	body, err := g.requestBody(w, r, request.ProtoReflect().Descriptor())
+	if err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	if err := protojson.Unmarshal(body, request); err != nil {
+		writeGatewayError(w, status.Errorf(codes.InvalidArgument, "Invalid request: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	stream := &httpLogStream{ctx: gatewayContext(r), writer: w}
+	handler := func(srv any, ss grpc.ServerStream) error {
+		// The interceptors may have wrapped the stream to change its context
+		stream.ctx = ss.Context()
+		return g.server.StreamLogs(request, stream)
+	}
+	if g.streamInterceptor == nil {
+		err = handler(g.server, stream)
+	} else {
+		info := &grpc.StreamServerInfo{FullMethod: AgentService_StreamLogs_FullMethodName, IsServerStream: true}
+		err = g.streamInterceptor(g.server, stream, info, handler)
+	}
+	if err != nil && !stream.started {
+		writeGatewayError(w, err)
+	}
+}
+
+// requestBody returns the JSON request, read from the body or built from the query parameters
+// along the fields of the request message
+func (g *Gateway) requestBody(w http.ResponseWriter, r *http.Request, message protoreflect.MessageDescriptor) ([]byte, error) {
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.maxBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			// writeGatewayError answers it with 413
+			return nil, err
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Failed to read request: %v", err)
+		}
+		return body, nil
+	}
+	if len(r.URL.Query()) == 0 {
+		return []byte("{}"), nil
+	}
+	// protojson takes integers and enums as strings, only booleans need converting
+	fields := map[string]any{}
+	for key, values := range r.URL.Query() {
+		field := message.Fields().ByJSONName(key)
+		if field == nil {
+			field = message.Fields().ByName(protoreflect.Name(key))
+		}
+		if field == nil {
+			// Left for protojson to report as an unknown field
+			fields[key] = values[0]
+			continue
+		}
+		if field.Kind() != protoreflect.BoolKind {
+			if field.IsList() {
+				fields[key] = values
+			} else {
+				fields[key] = values[0]
+			}
+			continue
+		}
+		flags := make([]bool, len(values))
+		for i, value := range values {
+			flag, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid boolean '%s' for field '%s'", value, key)
+			}
+			flags[i] = flag
+		}
+		if field.IsList() {
+			fields[key] = flags
+		} else {
+			fields[key] = flags[0]
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// gatewayContext carries the HTTP client address and headers like a gRPC call would
+func gatewayContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+	pairs := metadata.MD{}
+	for key, values := range r.Header {
+		pairs.Append(key, values...)
+	}
+	return metadata.NewIncomingContext(ctx, pairs)
+}
+
+// writeGatewayError writes a gRPC status as JSON with the matching HTTP status code
+func writeGatewayError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeGatewayStatus(w, http.StatusRequestEntityTooLarge,
+			status.Newf(codes.InvalidArgument, "Request body exceeds the limit of %d bytes", tooLarge.Limit))
+		return
+	}
+	st := status.Convert(err)
+	writeGatewayStatus(w, httpStatus(st.Code()), st)
+}
+
+// writeGatewayStatus writes a gRPC status as JSON with the given HTTP status code
+func writeGatewayStatus(w http.ResponseWriter, httpCode int, st *status.Status) {
+	data, marshalErr := protojson.Marshal(st.Proto())
+	if marshalErr != nil {
+		log.Printf("Failed to encode gateway error: %v", marshalErr)
+		data = []byte(`{"message":"internal error"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpCode)
+	_, _ = w.Write(data)
+}
+
+// httpStatus maps gRPC codes to HTTP status codes the way grpc-gateway does
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// chainUnaryInterceptors combines interceptors into one, the first being the outermost
+func chainUnaryInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// chainStreamInterceptors combines stream interceptors into one, the first being the outermost
+func chainStreamInterceptors(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(srv any, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// httpLogStream adapts an HTTP response to the StreamLogs server stream
+type httpLogStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	writer  http.ResponseWriter
+	started bool
+}
+
+func (h *httpLogStream) Context() context.Context {
+	return h.ctx
+}
+
+// SetHeader, SendHeader and SetTrailer are no-ops, the gateway sets its HTTP headers itself
+func (h *httpLogStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (h *httpLogStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (h *httpLogStream) SetTrailer(metadata.MD) {}
+
+func (h *httpLogStream) Send(chunk *LogChunk) error {
+	h.started = true
+	if _, err := h.writer.Write(chunk.Data); err != nil {
+		return err
+	}
+	if flusher, ok := h.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestGatewayRequestBodyLimit(t *testing.T) {
+	server, docker := newTestServer(config.Config{}, map[string]fakeResult{
+		"run": {stdout: "abc123\n"},
+	})
+	gateway := NewGateway(server, 64, nil, nil)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "within the limit", body: `{"image":"nginx"}`, status: http.StatusOK},
+		{name: "over the limit", body: `{"image":"nginx","envs":["` + strings.Repeat("A", 64) + `"]}`, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			gateway.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/task/start", strings.NewReader(tt.body)))
+			if recorder.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.status, recorder.Body.String())
+			}
+		})
+	}
+	if got := docker.commands("run"); len(got) != 1 {
+		t.Errorf("docker run ran %d times, want only for the request within the limit", len(got))
+	}
+}
+
+func TestGatewayQueryParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		want   []string
+	}{
+		{name: "numeric looking job id", url: "/api/v1/task/stop?jobId=1", status: http.StatusOK,
+			want: []string{"ps", "label=job-id=1"}},
+		{name: "proto field name", url: "/api/v1/task/stop?job_id=0", status: http.StatusOK,
+			want: []string{"ps", "label=job-id=0"}},
+		{name: "single value of a repeated field", url: "/api/v1/rpc/InspectTasks?names=web", status: http.StatusOK,
+			want: []string{"inspect", "web"}},
+		{name: "boolean field", url: "/api/v1/task/ls?managed_only=1", status: http.StatusOK,
+			want: []string{"ps", "label=" + ManagedLabel}},
+		{name: "invalid boolean", url: "/api/v1/task/ls?managedOnly=maybe", status: http.StatusBadRequest},
+		{name: "unknown field", url: "/api/v1/task/stop?container=web", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, docker := newTestServer(config.Config{}, map[string]fakeResult{
+				"ps -a":    {stdout: ""},
+				"ps -a -q": {stdout: "abc123\n"},
+				"inspect":  {stdout: "[]"},
+			})
+			recorder := httptest.NewRecorder()
+			NewGateway(server, 1024, nil, nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.status, recorder.Body.String())
+			}
+			if tt.want == nil {
+				return
+			}
+			for _, call := range docker.commands(tt.want[0]) {
+				if slices.Contains(call, tt.want[1]) {
+					return
+				}
+			}
+			t.Errorf("no docker %s with %q in %q", tt.want[0], tt.want[1], docker.calls)
+		})
+	}
+}
+
+func TestGatewayRpcMethods(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		status int
+	}{
+		{name: "read-only over GET", method: http.MethodGet, url: "/api/v1/rpc/GetVersion", status: http.StatusOK},
+		{name: "mutating over GET", method: http.MethodGet, url: "/api/v1/rpc/KillTask?name=web", status: http.StatusMethodNotAllowed},
+		{name: "cordon over GET", method: http.MethodGet, url: "/api/v1/rpc/Cordon", status: http.StatusMethodNotAllowed},
+		{name: "mutating over POST", method: http.MethodPost, url: "/api/v1/rpc/KillTask", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, docker := newTestServer(config.Config{}, nil)
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(tt.method, tt.url, strings.NewReader(`{"name":"web"}`))
+			NewGateway(server, 1024, nil, nil).ServeHTTP(recorder, request)
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.status, recorder.Body.String())
+			}
+			if tt.status == http.StatusMethodNotAllowed && len(docker.calls) != 0 {
+				t.Errorf("refused call ran docker: %q", docker.calls)
+			}
+		})
+	}
+}
+
+func TestGatewayStreamLogsInterceptors(t *testing.T) {
+	server, _ := newTestServer(config.Config{}, map[string]fakeResult{
+		"logs": {stdout: "hello\n"},
+	})
+	var method, requestId string
+	recordStream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		method, requestId = info.FullMethod, RequestId(ss.Context())
+		return handler(srv, ss)
+	}
+	gateway := NewGateway(server, 1024, nil, []grpc.StreamServerInterceptor{StreamRequestId, recordStream})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/task/log?name=web", nil)
+	request.Header.Set(RequestIdHeader, "req-1")
+	gateway.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK || !strings.HasSuffix(recorder.Body.String(), "hello\n") {
+		t.Fatalf("response = %d %q, want the logs", recorder.Code, recorder.Body.String())
+	}
+	if method != AgentService_StreamLogs_FullMethodName || requestId != "req-1" {
+		t.Errorf("interceptor saw method %q request id %q, want StreamLogs with req-1", method, requestId)
+	}
+}
+
+func TestOpenApiDocumentsGatewayRoutes(t *testing.T) {
+	spec, err := os.ReadFile("../proto/gateway.openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{"/api/v1/task/log:", "/api/v1/rpc/{method}:"}
+	for _, route := range gatewayRoutes {
+		paths = append(paths, route.path+":")
+	}
+	for _, path := range paths {
+		if !strings.Contains(string(spec), "\n  "+path+"\n") {
+			t.Errorf("gateway.openapi.yaml does not document %s", strings.TrimSuffix(path, ":"))
+		}
+	}
+}
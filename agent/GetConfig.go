@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"context"
+	"net"
+
+	"github.com/pelletier/go-toml/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetConfig returns the effective configuration as TOML with secrets redacted, the same view as the config command.
+// Only local clients may call it unless allowRemoteConfig is set
+func (s *GrpcServer) GetConfig(ctx context.Context, req *Empty) (*ConfigResponse, error) {
+	if !s.Config.Server.AllowRemoteConfig {
+		ip := net.ParseIP(clientIP(ctx))
+		if ip == nil || !ip.IsLoopback() {
+			return nil, status.Error(codes.PermissionDenied, "GetConfig is only available to local clients on this agent")
+		}
+	}
+	configMutex.Lock()
+	redacted := s.Config.Redacted()
+	configMutex.Unlock()
+	data, err := toml.Marshal(redacted)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to encode config: %v", err)
+	}
+	return &ConfigResponse{Toml: string(data)}, nil
+}
@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GpuLabel records the GPU indices a managed container was started with
+const GpuLabel = "cangling.gpus"
+
+// ManagedLabel marks every container launched by this agent
+const ManagedLabel = "managed-by=cangling-grpc"
+
+// collectGpus queries nvidia-smi for the GPUs installed on this node.
+// A node without nvidia-smi is treated as a CPU-only node and yields an empty list.
+func collectGpus(ctx context.Context) ([]Gpu, error) {
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		return []Gpu{}, nil
+	}
+	command := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu=index,name,memory.total", "--format=csv,noheader,nounits")
+	var commandOutput bytes.Buffer
+	var commandError bytes.Buffer
+	command.Stdout = &commandOutput
+	command.Stderr = &commandError
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("nvidia-smi failed: %v %s", err, strings.TrimSpace(commandError.String()))
+	}
+
+	gpus := []Gpu{}
+	for _, line := range strings.Split(commandOutput.String(), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			continue
+		}
+		slot, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil {
+			continue
+		}
+		memoryMb, _ := strconv.ParseInt(strings.TrimSpace(fields[2]), 10, 64)
+		gpus = append(gpus, Gpu{
+			Module: strings.TrimSpace(fields[1]),
+			Memory: memoryMb,
+			Slot:   int32(slot),
+		})
+	}
+	return gpus, nil
+}
+
+// gpuAllocations maps GPU index to the job id of the running managed container of this agent using it.
+// It has the scope of reservedMemoryMb, the containers of another agent sharing the node are not counted
+func (s *GrpcServer) gpuAllocations(ctx context.Context) (map[int32]string, error) {
+	args := append([]string{"ps"}, s.managedFilters()...)
+	output, err := s.dockerOutput(ctx, append(args,
+		"--format", fmt.Sprintf(`{{.Label "%s"}}|{{.Label "job-id"}}|{{.Names}}`, GpuLabel))...)
+	if err != nil {
+		return nil, err
+	}
+
+	allocations := make(map[int32]string)
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "|", 3)
+		if len(parts) < 3 || parts[0] == "" {
+			continue
+		}
+		owner := parts[1]
+		if owner == "" {
+			owner = parts[2]
+		}
+		for _, index := range strings.Split(parts[0], ",") {
+			slot, err := strconv.Atoi(strings.TrimSpace(index))
+			if err != nil {
+				continue
+			}
+			allocations[int32(slot)] = owner
+		}
+	}
+	return allocations, nil
+}
+
+// validateGpus checks the requested GPU indices against the node inventory and,
+// when rejectBusy is set, against the GPUs already held by other managed jobs
+func (s *GrpcServer) validateGpus(ctx context.Context, requested []int32, rejectBusy bool) error {
+	gpus, err := collectGpus(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to collect GPU inventory: %v", err)
+	}
+	if len(gpus) == 0 {
+		return status.Error(codes.InvalidArgument, "GPUs requested but no GPU is available on this node")
+	}
+
+	installed := make(map[int32]bool, len(gpus))
+	for _, gpu := range gpus {
+		installed[gpu.Slot] = true
+	}
+	seen := make(map[int32]bool, len(requested))
+	for _, id := range requested {
+		if !installed[id] {
+			return status.Errorf(codes.InvalidArgument, "GPU %d does not exist on this node (%d GPUs installed)", id, len(gpus))
+		}
+		if seen[id] {
+			return status.Errorf(codes.InvalidArgument, "GPU %d is requested more than once", id)
+		}
+		seen[id] = true
+	}
+
+	if !rejectBusy {
+		return nil
+	}
+	allocations, err := s.gpuAllocations(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to query GPU allocations: %v", err)
+	}
+	for _, id := range requested {
+		if owner, busy := allocations[id]; busy {
+			return status.Errorf(codes.ResourceExhausted, "GPU %d is already in use by job '%s'", id, owner)
+		}
+	}
+	return nil
+}
+
+// ListGpus returns the GPU inventory of the node and the managed task holding each GPU, empty on CPU-only nodes
+func (s *GrpcServer) ListGpus(ctx context.Context, req *Empty) (*ListGpusResponse, error) {
+	gpus, err := collectGpus(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to query GPUs: %v", err)
+	}
+	response := &ListGpusResponse{Gpus: []*GpuInfo{}}
+	if len(gpus) == 0 {
+		return response, nil
+	}
+	allocations, err := s.gpuAllocations(ctx)
+	if err != nil {
+		return nil, dockerStatus(fmt.Sprintf("Failed to query GPU allocations: %v", err), err.Error())
+	}
+	for _, gpu := range gpus {
+		owner, inUse := allocations[gpu.Slot]
+		response.Gpus = append(response.Gpus, &GpuInfo{
+			Slot:     gpu.Slot,
+			Module:   gpu.Module,
+			MemoryMb: gpu.Memory,
+			InUse:    inUse,
+			TaskId:   owner,
+		})
+	}
+	return response, nil
+}
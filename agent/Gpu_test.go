@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestGpuAllocationsScopedLikeReservedMemory(t *testing.T) {
+	cfg := config.Config{}
+	cfg.Task.NamePrefix = "team-"
+	server, docker := newTestServer(cfg, map[string]fakeResult{
+		"ps": {stdout: "0,1|job-1|team-a\n|job-2|team-b\n"},
+	})
+
+	allocations, err := server.gpuAllocations(context.Background())
+	if err != nil {
+		t.Fatalf("gpuAllocations: %v", err)
+	}
+	if want := map[int32]string{0: "job-1", 1: "job-1"}; !reflect.DeepEqual(allocations, want) {
+		t.Errorf("allocations = %v, want %v", allocations, want)
+	}
+	if _, err := server.reservedMemoryMb(context.Background()); err != nil {
+		t.Fatalf("reservedMemoryMb: %v", err)
+	}
+	commands := docker.commands("ps")
+	if len(commands) != 2 {
+		t.Fatalf("docker ps calls = %q, want 2", commands)
+	}
+	for _, argv := range commands {
+		if !slices.Contains(argv, "label="+PrefixLabel+"=team-") {
+			t.Errorf("docker ps argv %q does not select the agent's prefix", argv)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HeartbeatSchedule spreads the heartbeats of nodes that started together, so they don't hit the control plane at once
+type HeartbeatSchedule struct {
+	interval time.Duration
+	jitter   float64
+	random   *rand.Rand
+}
+
+// NewHeartbeatSchedule seeds the jitter from the node identity, so each node keeps its own stable offset pattern
+func NewHeartbeatSchedule(serverConfig config.ServerConfig, interval time.Duration) *HeartbeatSchedule {
+	percent := serverConfig.HeartbeatJitterPercent
+	if percent == 0 {
+		percent = 10
+	}
+	if percent < 0 {
+		percent = 0
+	}
+	hostName, _ := os.Hostname()
+	seed := fnv.New64a()
+	seed.Write([]byte(serverConfig.AgentId + "/" + hostName))
+	return &HeartbeatSchedule{
+		interval: interval,
+		jitter:   float64(min(percent, 100)) / 100,
+		random:   rand.New(rand.NewPCG(seed.Sum64(), seed.Sum64()>>1)),
+	}
+}
+
+// FirstDelay is a random point within the first interval
+func (h *HeartbeatSchedule) FirstDelay() time.Duration {
+	if h.jitter == 0 {
+		return h.interval
+	}
+	return time.Duration(h.random.Float64() * float64(h.interval))
+}
+
+// Next is the interval shifted by up to the jitter percentage either way
+func (h *HeartbeatSchedule) Next() time.Duration {
+	offset := (h.random.Float64()*2 - 1) * h.jitter
+	return time.Duration(float64(h.interval) * (1 + offset))
+}
+
+// lastReportSuccess is the Unix time in milliseconds of the last heartbeat the server accepted, 0 before the first
+var lastReportSuccess atomic.Int64
+
+// agentStarted is the baseline for the heartbeat age until the first heartbeat succeeds
+var agentStarted = time.Now()
+
+// bootTime is the Unix time in milliseconds the host booted, read once from /proc/stat, 0 where that is not available
+var bootTime = sync.OnceValue(func() int64 {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, found := strings.CutPrefix(line, "btime "); found {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err == nil {
+				return seconds * 1000
+			}
+		}
+	}
+	return 0
+})
+
+// HeartbeatAge is how long ago the server last accepted a heartbeat, or how long the agent has run without one
+func HeartbeatAge() time.Duration {
+	if last := lastReportSuccess.Load(); last > 0 {
+		return time.Since(time.UnixMilli(last))
+	}
+	return time.Since(agentStarted)
+}
+
+// Actions a ReportWatchdog takes once the heartbeat keeps failing
+const (
+	ReportFailureLog    = "log"
+	ReportFailureCordon = "cordon"
+	ReportFailureExit   = "exit"
+)
+
+// ReportWatchdog counts heartbeats failing in a row and acts when they reach the configured threshold,
+// so a node cut off from the control plane does not go on unnoticed
+type ReportWatchdog struct {
+	threshold int
+	action    string
+	failures  int
+	// cordoned is set when the watchdog cordoned the node, which it then undoes on recovery
+	cordoned bool
+}
+
+// NewReportWatchdog validates the configured action
+func NewReportWatchdog(serverConfig config.ServerConfig) (*ReportWatchdog, error) {
+	action := serverConfig.ReportFailureAction
+	if action == "" {
+		action = ReportFailureLog
+	}
+	if action != ReportFailureLog && action != ReportFailureCordon && action != ReportFailureExit {
+		return nil, fmt.Errorf("invalid reportFailureAction '%s', must be log, cordon or exit", action)
+	}
+	return &ReportWatchdog{threshold: serverConfig.ReportFailureThreshold, action: action}, nil
+}
+
+// Success resets the failure count, lifting the cordon the watchdog set but not one set by an operator
+func (w *ReportWatchdog) Success() {
+	w.failures = 0
+	if w.cordoned {
+		w.cordoned = false
+		SetCordoned(CordonWatchdog, false)
+		if IsCordoned() {
+			log.Printf("Heartbeat recovered, the node stays cordoned by the operator")
+		} else {
+			log.Printf("Heartbeat recovered, accepting new tasks again")
+		}
+	}
+}
+
+// Failure counts a failed heartbeat and takes the action when the threshold is reached
+func (w *ReportWatchdog) Failure(err error) {
+	w.failures++
+	if w.threshold <= 0 || w.failures != w.threshold {
+		return
+	}
+	switch w.action {
+	case ReportFailureExit:
+		log.Fatalf("ERROR: %d heartbeats in a row failed, exiting: %v", w.failures, err)
+	case ReportFailureCordon:
+		// Only in memory, unlike the cordon command, so a restart or recovery brings the node back
+		SetCordoned(CordonWatchdog, true)
+		w.cordoned = true
+		log.Printf("ERROR: %d heartbeats in a row failed, node cordoned until the control plane is reachable: %v", w.failures, err)
+	default:
+		log.Printf("ERROR: %d heartbeats in a row failed, this node is disconnected from the control plane: %v", w.failures, err)
+	}
+}
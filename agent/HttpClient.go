@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// httpClient is shared by all requests to the control plane, see ConfigureHttpClient
+var httpClient = &http.Client{
+	Timeout: 10 * time.Second, // Set a timeout for the request
+}
+
+// ConfigureHttpClient builds the client used by Register and ReportAgentToServer from the server config
+func ConfigureHttpClient(serverConfig config.ServerConfig) error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFunc(serverConfig)
+	tlsConfig, err := tlsClientConfig(serverConfig)
+	if err != nil {
+		return err
+	}
+	transport.TLSClientConfig = tlsConfig
+	var roundTripper http.RoundTripper = transport
+	if TracingEnabled(serverConfig) {
+		// Client spans, with the trace context passed on to the control plane
+		roundTripper = otelhttp.NewTransport(transport)
+	}
+	httpClient = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: roundTripper,
+	}
+	return nil
+}
+
+// tlsClientConfig trusts the system roots plus the optional ServerCaFile bundle,
+// and presents the optional client certificate for mutual TLS
+func tlsClientConfig(serverConfig config.ServerConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if serverConfig.ServerCaFile != "" {
+		pem, err := os.ReadFile(serverConfig.ServerCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read serverCaFile: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("serverCaFile %s contains no PEM certificate", serverConfig.ServerCaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if serverConfig.ClientCertFile != "" || serverConfig.ClientKeyFile != "" {
+		if serverConfig.ClientCertFile == "" || serverConfig.ClientKeyFile == "" {
+			return nil, fmt.Errorf("clientCertFile and clientKeyFile must be set together")
+		}
+		certificate, err := tls.LoadX509KeyPair(serverConfig.ClientCertFile, serverConfig.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate %s: %w", serverConfig.ClientCertFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	if serverConfig.InsecureSkipVerify {
+		log.Printf("WARNING: insecureSkipVerify is set, the control plane certificate is NOT verified. " +
+			"Anyone on the network path can impersonate the server. Use serverCaFile instead")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// proxyFunc uses the configured proxies, or the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment when none is set
+func proxyFunc(serverConfig config.ServerConfig) func(*http.Request) (*url.URL, error) {
+	if serverConfig.HttpProxy == "" && serverConfig.HttpsProxy == "" && serverConfig.NoProxy == "" {
+		return http.ProxyFromEnvironment
+	}
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  serverConfig.HttpProxy,
+		HTTPSProxy: serverConfig.HttpsProxy,
+		NoProxy:    serverConfig.NoProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
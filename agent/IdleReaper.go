@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IdleTimeoutLabel opts a container into the idle reaper, its value being the idle minutes before it is stopped
+const IdleTimeoutLabel = "cangling.idle-timeout"
+
+// reapedTasks are the idle containers stopped by the reaper, reported until the server acknowledges them.
+// FinishedAt is the time the container was reaped
+var reapedTasks = &exitTracker{}
+
+// RunIdleReaper stops opted-in containers that neither logged nor used CPU for their idle timeout, until ctx is done
+func RunIdleReaper(ctx context.Context, taskConfig config.TaskConfig) {
+	if !taskConfig.IdleReaperEnabled {
+		return
+	}
+	interval := time.Duration(taskConfig.IdleReaperIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	cpuThreshold := taskConfig.IdleCpuPercent
+	if cpuThreshold <= 0 {
+		cpuThreshold = 1
+	}
+	log.Printf("Idle reaper enabled, checking every %v", interval)
+
+	lastActive := map[string]time.Time{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := reapIdle(ctx, lastActive, cpuThreshold, taskConfig.NamePrefix); err != nil {
+				log.Printf("Idle reaper failed: %v", err)
+			}
+		}
+	}
+}
+
+// reapIdle updates the last activity of every opted-in container of the agent using namePrefix
+// and stops those idle for too long
+func reapIdle(ctx context.Context, lastActive map[string]time.Time, cpuThreshold float64, namePrefix string) error {
+	args := append([]string{"ps", "-q", "--no-trunc"}, managedFiltersFor(namePrefix)...)
+	output, err := dockerOutput(ctx, append(args, "--filter", "label="+IdleTimeoutLabel)...)
+	if err != nil {
+		return err
+	}
+	ids := strings.Fields(output)
+	running := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		running[id] = true
+	}
+	for id := range lastActive {
+		if !running[id] {
+			delete(lastActive, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	output, err = dockerOutput(ctx, append([]string{"inspect", "--type", "container"}, ids...)...)
+	if err != nil {
+		return err
+	}
+	var containers []containerInspect
+	if err := json.Unmarshal([]byte(output), &containers); err != nil {
+		return err
+	}
+	cpu, err := cpuPercents(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, container := range containers {
+		minutes, err := strconv.Atoi(container.Config.Labels[IdleTimeoutLabel])
+		if err != nil || minutes <= 0 {
+			continue
+		}
+		since, seen := lastActive[container.Id]
+		if !seen {
+			since, _ = time.Parse(time.RFC3339Nano, container.State.StartedAt)
+		}
+		if cpu[container.Id] >= cpuThreshold || hasLogsSince(ctx, container.Id, since) {
+			lastActive[container.Id] = now
+			continue
+		}
+		lastActive[container.Id] = since
+		if now.Sub(since) < time.Duration(minutes)*time.Minute {
+			continue
+		}
+
+		log.Printf("Stopping container %s, idle since %s", container.Name, since.Format(time.RFC3339))
+		if _, err := dockerOutput(ctx, "stop", container.Id); err != nil {
+			log.Printf("Failed to stop idle container %s: %v", container.Name, err)
+			continue
+		}
+		delete(lastActive, container.Id)
+		reapedTasks.record(ExitedTask{
+			Id:         container.Id,
+			Name:       strings.TrimPrefix(strings.TrimPrefix(container.Name, "/"), namePrefix),
+			JobId:      container.Config.Labels["job-id"],
+			FinishedAt: now.UnixMilli(),
+		})
+	}
+	return nil
+}
+
+// cpuPercents samples the CPU usage of the containers, keyed by full container ID
+func cpuPercents(ctx context.Context, ids []string) (map[string]float64, error) {
+	args := append([]string{"stats", "--no-stream", "--no-trunc", "--format", "{{.ID}} {{.CPUPerc}}"}, ids...)
+	output, err := dockerOutput(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	percents := map[string]float64{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "%"), 64)
+		if err == nil {
+			percents[fields[0]] = percent
+		}
+	}
+	return percents, nil
+}
+
+// hasLogsSince reports whether the container wrote any log line after the given time
+func hasLogsSince(ctx context.Context, containerID string, since time.Time) bool {
+	// Both streams, as docker logs replays the container's stderr on its own stderr
+	stdout, stderr, err := defaultDocker.Run(ctx, "logs", "--since", since.Format(time.RFC3339), "--tail", "1", containerID)
+	return err == nil && strings.TrimSpace(stdout+stderr) != ""
+}
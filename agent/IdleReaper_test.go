@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReapIdleOnlyListsOwnTasks(t *testing.T) {
+	docker := useFakeDocker(t, nil)
+
+	if err := reapIdle(context.Background(), map[string]time.Time{}, 1, "team-"); err != nil {
+		t.Fatalf("reapIdle: %v", err)
+	}
+	want := [][]string{{"ps", "-q", "--no-trunc",
+		"--filter", "label=" + ManagedLabel, "--filter", "label=" + PrefixLabel + "=team-",
+		"--filter", "label=" + IdleTimeoutLabel}}
+	if got := docker.commands("ps"); !reflect.DeepEqual(got, want) {
+		t.Errorf("docker ps argv =\n  %q\nwant\n  %q", got, want)
+	}
+}
+
+func TestHasLogsSinceReadsBothStreams(t *testing.T) {
+	since := time.Date(2024, 1, 2, 13, 23, 37, 0, time.UTC)
+	docker := useFakeDocker(t, map[string]fakeResult{"logs": {stderr: "warning\n"}})
+
+	if !hasLogsSince(context.Background(), "abc123", since) {
+		t.Errorf("hasLogsSince = false for a line on stderr")
+	}
+	want := [][]string{{"logs", "--since", "2024-01-02T13:23:37Z", "--tail", "1", "abc123"}}
+	if got := docker.commands("logs"); !reflect.DeepEqual(got, want) {
+		t.Errorf("docker logs argv =\n  %q\nwant\n  %q", got, want)
+	}
+
+	docker.results["logs"] = fakeResult{}
+	if hasLogsSince(context.Background(), "abc123", since) {
+		t.Errorf("hasLogsSince = true without output")
+	}
+}
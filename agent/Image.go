@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultRegistry = "docker.io"
+
+// imageRegistry returns the registry host of an image reference, docker.io when none is given
+func imageRegistry(image string) string {
+	slash := strings.Index(image, "/")
+	if slash < 0 {
+		return defaultRegistry
+	}
+	host := image[:slash]
+	if host == "localhost" || strings.ContainsAny(host, ".:") {
+		return host
+	}
+	return defaultRegistry
+}
+
+// imageDigest returns the sha256 digest pinned in an image reference, or "" if the reference is not pinned
+func imageDigest(image string) string {
+	at := strings.LastIndex(image, "@")
+	if at < 0 || !strings.HasPrefix(image[at+1:], "sha256:") {
+		return ""
+	}
+	return image[at+1:]
+}
+
+// checkRegistry rejects images from registries outside the allow-list, an empty list allows all
+func checkRegistry(image string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	registry := imageRegistry(image)
+	for _, entry := range allowed {
+		if strings.EqualFold(entry, registry) {
+			return nil
+		}
+	}
+	return status.Errorf(codes.PermissionDenied, "Registry '%s' of image '%s' is not allowed", registry, image)
+}
+
+// checkImageAllowed rejects images matching none of the allowed patterns, an empty list allows all
+func checkImageAllowed(image string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, pattern := range allowed {
+		if strings.ContainsAny(pattern, "*?[") {
+			if matched, err := path.Match(pattern, image); err == nil && matched {
+				return nil
+			}
+		} else if strings.HasPrefix(image, pattern) {
+			return nil
+		}
+	}
+	return status.Errorf(codes.FailedPrecondition, "Image '%s' is not in the allowed images of this agent", image)
+}
+
+// verifyImageDigest pulls a digest pinned image and verifies the local image carries that digest
+func (s *GrpcServer) verifyImageDigest(ctx context.Context, image string) error {
+	digest := imageDigest(image)
+	if digest == "" {
+		return nil
+	}
+	if _, err := s.dockerOutput(ctx, "pull", "--quiet", image); err != nil {
+		return status.Errorf(codes.Internal, "Failed to pull image: %v", err)
+	}
+	output, err := s.dockerOutput(ctx, "image", "inspect", "--format", "{{json .RepoDigests}}", image)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to inspect image: %v", err)
+	}
+	var repoDigests []string
+	if err := json.Unmarshal([]byte(output), &repoDigests); err != nil {
+		return status.Errorf(codes.Internal, "Unexpected image inspect output: %s", output)
+	}
+	for _, repoDigest := range repoDigests {
+		if strings.HasSuffix(repoDigest, "@"+digest) {
+			return nil
+		}
+	}
+	return status.Errorf(codes.FailedPrecondition, "Image '%s' does not match digest %s, found %v", image, digest, repoDigests)
+}
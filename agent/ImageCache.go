@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedImage is an image present on the node, reported so the control plane can prefer nodes that need no pull
+type CachedImage struct {
+	// Reference is repository:tag
+	Reference string `json:"reference"`
+	// Digest is the repository digest, empty for images built locally
+	Digest string `json:"digest,omitempty"`
+}
+
+// imageCache keeps the last image listing, docker images is only run again once it is stale
+type imageCache struct {
+	mutex     sync.Mutex
+	images    []CachedImage
+	refreshed time.Time
+}
+
+var cachedImages = &imageCache{}
+
+// list returns the cached images, refreshing them when older than the configured interval
+func (c *imageCache) list(ctx context.Context, serverConfig config.ServerConfig) []CachedImage {
+	refreshMinutes := serverConfig.ImageRefreshMinutes
+	if refreshMinutes <= 0 {
+		refreshMinutes = 5
+	}
+	maxImages := serverConfig.MaxReportedImages
+	if maxImages <= 0 {
+		maxImages = 200
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.refreshed.IsZero() && time.Since(c.refreshed) < time.Duration(refreshMinutes)*time.Minute {
+		return c.images
+	}
+	images, err := listImages(ctx, maxImages)
+	if err != nil {
+		// Keep reporting the previous listing, it is retried with the next heartbeat
+		log.Printf("Failed to list images: %v", err)
+		return c.images
+	}
+	c.images = images
+	c.refreshed = time.Now()
+	return c.images
+}
+
+// listImages returns up to maxImages tagged images, newest first as docker lists them
+func listImages(ctx context.Context, maxImages int) ([]CachedImage, error) {
+	output, err := dockerOutput(ctx, "images", "--digests", "--format", "{{.Repository}}\t{{.Tag}}\t{{.Digest}}")
+	if err != nil {
+		return nil, err
+	}
+	images := []CachedImage{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != 3 || fields[0] == "<none>" || fields[1] == "<none>" {
+			continue
+		}
+		image := CachedImage{Reference: fields[0] + ":" + fields[1]}
+		if fields[2] != "<none>" {
+			image.Digest = fields[2]
+		}
+		images = append(images, image)
+		if len(images) == maxImages {
+			break
+		}
+	}
+	return images, nil
+}
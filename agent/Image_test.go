@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckImageAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		allowed []string
+		code    codes.Code
+	}{
+		{name: "empty list allows all", image: "nginx:latest"},
+		{name: "prefix", image: "registry.example.com/team/app:1.2", allowed: []string{"registry.example.com/team/"}},
+		{name: "outside the prefix", image: "registry.example.com/other/app:1.2", allowed: []string{"registry.example.com/team/"}, code: codes.FailedPrecondition},
+		{name: "exact", image: "nginx:1.27", allowed: []string{"busybox", "nginx:1.27"}},
+		{name: "wildcard tag", image: "nginx:1.27", allowed: []string{"nginx:*"}},
+		{name: "wildcard does not cross a slash", image: "library/nginx:1.27", allowed: []string{"*:1.27"}, code: codes.FailedPrecondition},
+		{name: "wildcard repository", image: "registry.example.com/team/app:1.2", allowed: []string{"registry.example.com/*/app:*"}},
+		{name: "no pattern matches", image: "alpine:3", allowed: []string{"nginx:*", "busybox"}, code: codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkImageAllowed(tt.image, tt.allowed)
+			if status.Code(err) != tt.code {
+				t.Errorf("checkImageAllowed(%q, %q) = %v, want code %v", tt.image, tt.allowed, err, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultRedactEnvPattern matches environment variable names whose values are hidden in InspectTask
+const DefaultRedactEnvPattern = `(?i)(TOKEN|SECRET|PASSWORD|PASSWD|KEY|CREDENTIAL)`
+
+const redactedValue = "******"
+
+// containerInspect is the subset of `docker inspect` the agent reports
+type containerInspect struct {
+	Id           string `json:"Id"`
+	Name         string `json:"Name"`
+	RestartCount int    `json:"RestartCount"`
+	State        struct {
+		Status     string `json:"Status"`
+		Running    bool   `json:"Running"`
+		ExitCode   int    `json:"ExitCode"`
+		StartedAt  string `json:"StartedAt"`
+		FinishedAt string `json:"FinishedAt"`
+	} `json:"State"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Env    []string          `json:"Env"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	HostConfig struct {
+		Memory int64 `json:"Memory"`
+	} `json:"HostConfig"`
+}
+
+// InspectTask reports the state and configuration of a container, with secret env values redacted
+func (s *GrpcServer) InspectTask(ctx context.Context, req *InspectTaskRequest) (*InspectTaskResponse, error) {
+	containerName, targetName, err := s.taskTarget(ctx, req.Name, req.JobId)
+	if err != nil {
+		return nil, err
+	}
+	redactPattern, err := s.redactEnvPattern()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Invalid redactEnvPattern in config: %v", err)
+	}
+
+	output, err := s.dockerOutput(ctx, "inspect", "--type", "container", containerName)
+	if err != nil {
+		if strings.Contains(err.Error(), "No such") {
+			return nil, status.Errorf(codes.NotFound, "Container '%s' does not exist", targetName)
+		}
+		return nil, status.Errorf(codes.Internal, "Failed to inspect task: %v", err)
+	}
+	var inspected []containerInspect
+	if err := json.Unmarshal([]byte(output), &inspected); err != nil || len(inspected) == 0 {
+		return nil, status.Errorf(codes.Internal, "Unexpected docker inspect output: %v", err)
+	}
+	return s.inspectResponse(inspected[0], redactPattern), nil
+}
+
+// InspectTasks inspects the named containers and those matching the selector with a single docker inspect.
+// Names that do not exist are listed in not_found instead of failing the call
+func (s *GrpcServer) InspectTasks(ctx context.Context, req *InspectTasksRequest) (*InspectTasksResponse, error) {
+	terms := selectorTerms(req.Selector)
+	if len(req.Names) == 0 && len(terms) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Field 'names' or 'selector' is required")
+	}
+	redactPattern, err := s.redactEnvPattern()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Invalid redactEnvPattern in config: %v", err)
+	}
+
+	var refs []string
+	for _, name := range req.Names {
+		if name == "" {
+			return nil, status.Error(codes.InvalidArgument, "Field 'names' must not contain empty names")
+		}
+		refs = append(refs, s.containerName(name))
+	}
+	if len(terms) > 0 {
+		containerIDs, err := s.selectContainers(ctx, terms, true)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to list containers: %v", err)
+		}
+		refs = append(refs, containerIDs...)
+	}
+
+	response := &InspectTasksResponse{Tasks: map[string]*InspectTaskResponse{}}
+	if len(refs) == 0 {
+		return response, nil
+	}
+	// docker inspect still prints the containers it found when some names are missing
+	stdout, stderr, err := s.Docker.Run(ctx, append([]string{"inspect", "--type", "container"}, refs...)...)
+	if err != nil && !strings.Contains(stderr, "No such") {
+		return nil, dockerStatus(fmt.Sprintf("Failed to inspect tasks: %v: %s", err, strings.TrimSpace(stderr)), stderr)
+	}
+	var inspected []containerInspect
+	if strings.TrimSpace(stdout) != "" {
+		if err := json.Unmarshal([]byte(stdout), &inspected); err != nil {
+			return nil, status.Errorf(codes.Internal, "Unexpected docker inspect output: %v", err)
+		}
+	}
+	for _, container := range inspected {
+		task := s.inspectResponse(container, redactPattern)
+		response.Tasks[task.Name] = task
+	}
+	for _, name := range req.Names {
+		if _, found := response.Tasks[name]; !found {
+			response.NotFound = append(response.NotFound, name)
+		}
+	}
+	return response, nil
+}
+
+// inspectResponse converts docker inspect output to the InspectTask response
+func (s *GrpcServer) inspectResponse(container containerInspect, redactPattern *regexp.Regexp) *InspectTaskResponse {
+	return &InspectTaskResponse{
+		Id:           container.Id,
+		Name:         s.clientName(container.Name),
+		Image:        container.Config.Image,
+		Status:       container.State.Status,
+		Running:      container.State.Running,
+		ExitCode:     int32(container.State.ExitCode),
+		StartedAt:    container.State.StartedAt,
+		FinishedAt:   container.State.FinishedAt,
+		Envs:         redactEnv(container.Config.Env, redactPattern),
+		Labels:       container.Config.Labels,
+		RestartCount: int32(container.RestartCount),
+
+		RequestedMemoryMb: requestedMemoryMb(container.Config.Labels),
+		RequestedGpus:     requestedGpus(container.Config.Labels),
+		MemoryLimitMb:     container.HostConfig.Memory / 1024 / 1024,
+	}
+}
+
+// inspectManaged inspects every managed container of the agent using namePrefix, for the heartbeat
+func inspectManaged(ctx context.Context, namePrefix string) ([]containerInspect, error) {
+	output, err := dockerOutput(ctx, append([]string{"ps", "-a", "-q", "--no-trunc"}, managedFiltersFor(namePrefix)...)...)
+	if err != nil {
+		return nil, err
+	}
+	ids := strings.Fields(output)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	output, err = dockerOutput(ctx, append([]string{"inspect", "--type", "container"}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	var inspected []containerInspect
+	if err := json.Unmarshal([]byte(output), &inspected); err != nil {
+		return nil, err
+	}
+	return inspected, nil
+}
+
+// taskKey is the job ID of a container, or its name when there is none
+func taskKey(container containerInspect) string {
+	if key := container.Config.Labels["job-id"]; key != "" {
+		return key
+	}
+	return strings.TrimPrefix(container.Name, "/")
+}
+
+// restartCounts maps the task key of every managed container that has restarted to its restart count
+func restartCounts(inspected []containerInspect) map[string]int32 {
+	counts := map[string]int32{}
+	for _, container := range inspected {
+		if container.RestartCount > 0 {
+			counts[taskKey(container)] = int32(container.RestartCount)
+		}
+	}
+	return counts
+}
+
+func (s *GrpcServer) redactEnvPattern() (*regexp.Regexp, error) {
+	pattern := s.Config.Task.RedactEnvPattern
+	if pattern == "" {
+		pattern = DefaultRedactEnvPattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// redactEnv hides the values of KEY=VALUE entries whose key matches pattern
+func redactEnv(envs []string, pattern *regexp.Regexp) []string {
+	redacted := make([]string, 0, len(envs))
+	for _, env := range envs {
+		key, _, found := strings.Cut(env, "=")
+		if found && pattern.MatchString(key) {
+			env = key + "=" + redactedValue
+		}
+		redacted = append(redacted, env)
+	}
+	return redacted
+}
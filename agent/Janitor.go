@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// reclaimedBytes is the disk space freed by the janitor since the agent started
+var reclaimedBytes atomic.Uint64
+
+// RunJanitor periodically prunes exited managed containers, and optionally dangling images,
+// until ctx is done. Running containers and containers not launched by the agent are never touched.
+func RunJanitor(ctx context.Context, taskConfig config.TaskConfig) {
+	if !taskConfig.JanitorEnabled {
+		return
+	}
+	interval := time.Duration(taskConfig.JanitorIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	maxAge := time.Duration(taskConfig.JanitorMaxAgeHours) * time.Hour
+	if maxAge <= 0 {
+		maxAge = 24 * time.Hour
+	}
+	log.Printf("Janitor enabled, pruning exited tasks older than %v every %v", maxAge, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			prune(ctx, maxAge, taskConfig)
+		}
+	}
+}
+
+func prune(ctx context.Context, maxAge time.Duration, taskConfig config.TaskConfig) {
+	filters := managedFiltersFor(taskConfig.NamePrefix)
+	args := append([]string{"container", "prune", "--force"}, filters...)
+	output, err := dockerOutput(ctx, append(args, "--filter", fmt.Sprintf("until=%s", maxAge))...)
+	if err != nil {
+		log.Printf("Janitor failed to prune containers: %v", err)
+	} else {
+		recordReclaimed("containers", output)
+	}
+	if taskConfig.JanitorPruneVolumes {
+		// Only volumes no container uses any more. Docker 23+ prunes only anonymous volumes without --all
+		output, err = dockerOutput(ctx, append([]string{"volume", "prune", "--force", "--all"}, filters...)...)
+		if err != nil {
+			log.Printf("Janitor failed to prune volumes: %v", err)
+		} else {
+			recordReclaimed("volumes", output)
+		}
+	}
+	if !taskConfig.JanitorPruneImages {
+		return
+	}
+	// Without -a only dangling (untagged) images are removed
+	output, err = dockerOutput(ctx, "image", "prune", "--force")
+	if err != nil {
+		log.Printf("Janitor failed to prune images: %v", err)
+	} else {
+		recordReclaimed("images", output)
+	}
+}
+
+// recordReclaimed parses the "Total reclaimed space: 1.2GB" line of docker prune
+func recordReclaimed(kind string, output string) {
+	const marker = "Total reclaimed space:"
+	index := strings.Index(output, marker)
+	if index < 0 {
+		return
+	}
+	size, err := parseByteSize(strings.TrimSpace(strings.SplitN(output[index+len(marker):], "\n", 2)[0]))
+	if err != nil {
+		log.Printf("Janitor could not parse reclaimed space: %v", err)
+		return
+	}
+	if size > 0 {
+		log.Printf("Janitor pruned %s, reclaimed %d bytes", kind, size)
+	}
+	reclaimedBytes.Add(size)
+}
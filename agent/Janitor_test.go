@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useFakeDocker points the background work at a fresh fakeDocker for the duration of the test
+func useFakeDocker(t *testing.T, results map[string]fakeResult) *fakeDocker {
+	docker := &fakeDocker{results: results}
+	previous := defaultDocker
+	defaultDocker = docker
+	t.Cleanup(func() { defaultDocker = previous })
+	return docker
+}
+
+func TestPruneOnlyTouchesOwnTasks(t *testing.T) {
+	docker := useFakeDocker(t, nil)
+	taskConfig := config.TaskConfig{NamePrefix: "team-", JanitorPruneVolumes: true}
+
+	prune(context.Background(), 24*time.Hour, taskConfig)
+
+	want := [][]string{{"container", "prune", "--force",
+		"--filter", "label=" + ManagedLabel, "--filter", "label=" + PrefixLabel + "=team-",
+		"--filter", "until=24h0m0s"}}
+	if got := docker.commands("container"); !reflect.DeepEqual(got, want) {
+		t.Errorf("container prune argv =\n  %q\nwant\n  %q", got, want)
+	}
+	want = [][]string{{"volume", "prune", "--force", "--all",
+		"--filter", "label=" + ManagedLabel, "--filter", "label=" + PrefixLabel + "=team-"}}
+	if got := docker.commands("volume"); !reflect.DeepEqual(got, want) {
+		t.Errorf("volume prune argv =\n  %q\nwant\n  %q", got, want)
+	}
+}
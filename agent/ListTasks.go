@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// dockerContainer is the subset of `docker ps --format "{{json .}}"` reported by ListTasks
+type dockerContainer struct {
+	ID        string `json:"ID"`
+	Names     string `json:"Names"`
+	Image     string `json:"Image"`
+	State     string `json:"State"`
+	Status    string `json:"Status"`
+	CreatedAt string `json:"CreatedAt"`
+	Labels    string `json:"Labels"`
+}
+
+// ListTasks implements GET /api/v1/task/ls
+func (s *GrpcServer) ListTasks(ctx context.Context, req *ListTasksRequest) (*ListTasksResponse, error) {
+	less, ok := taskOrders[req.SortBy]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown sort_by '%s', must be created, name or status", req.SortBy)
+	}
+	var filters []string
+	if req.ManagedOnly {
+		filters = s.managedFilters()
+	}
+
+	var output bytes.Buffer
+	if err := s.Docker.Stream(ctx, &output, &output, append([]string{"ps", "-a"}, filters...)...); err != nil {
+		return nil, dockerStatus(s.truncateOutput(fmt.Sprintf("Failed to list tasks: %v", err)), output.String())
+	}
+
+	tasks, err := s.listContainers(ctx, filters)
+	if err != nil {
+		return nil, dockerStatus(fmt.Sprintf("Failed to list tasks: %v", err), err.Error())
+	}
+	if less != nil {
+		sort.SliceStable(tasks, func(i, j int) bool {
+			if req.Descending {
+				return less(tasks[j], tasks[i])
+			}
+			return less(tasks[i], tasks[j])
+		})
+	}
+	return &ListTasksResponse{
+		Output: s.truncateOutput(s.stripNamePrefix(output.String())),
+		Tasks:  pageTasks(tasks, req.Offset, req.Limit),
+		Total:  int32(len(tasks)),
+	}, nil
+}
+
+// taskOrders are the ListTasks sort_by values, the empty one keeps docker's order
+var taskOrders = map[string]func(a, b *TaskInfo) bool{
+	"":        nil,
+	"created": func(a, b *TaskInfo) bool { return a.AgeSeconds > b.AgeSeconds },
+	"name":    func(a, b *TaskInfo) bool { return a.Name < b.Name },
+	"status":  func(a, b *TaskInfo) bool { return a.State < b.State },
+}
+
+// createdAtLayouts are the CreatedAt formats of docker ps, Go's time.String form on current versions
+var createdAtLayouts = []string{
+	"2006-01-02 15:04:05 -0700 MST",
+	"2006-01-02 15:04:05 -0700",
+	time.RFC3339Nano,
+}
+
+// taskAge returns the seconds since a docker ps CreatedAt timestamp, 0 when it cannot be parsed
+func taskAge(createdAt string, now time.Time) int64 {
+	// Some versions append a monotonic clock reading, e.g. "m=+0.1"
+	createdAt, _, _ = strings.Cut(createdAt, " m=")
+	for _, layout := range createdAtLayouts {
+		if created, err := time.Parse(layout, createdAt); err == nil {
+			return max(int64(now.Sub(created).Seconds()), 0)
+		}
+	}
+	return 0
+}
+
+// pageTasks slices one page out of the listed tasks, docker ps has no paging of its own
+func pageTasks(tasks []*TaskInfo, offset int32, limit int32) []*TaskInfo {
+	if offset < 0 || int(offset) >= len(tasks) {
+		return nil
+	}
+	tasks = tasks[offset:]
+	if limit > 0 && int(limit) < len(tasks) {
+		tasks = tasks[:limit]
+	}
+	return tasks
+}
+
+// listContainers returns all containers matching the docker ps filters
+func (s *GrpcServer) listContainers(ctx context.Context, filters []string) ([]*TaskInfo, error) {
+	args := append([]string{"ps", "-a", "--no-trunc", "--format", "{{json .}}"}, filters...)
+	output, err := s.dockerOutput(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*TaskInfo
+	now := time.Now()
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var container dockerContainer
+		if err := json.Unmarshal([]byte(line), &container); err != nil {
+			return nil, fmt.Errorf("unexpected docker ps output: %v", err)
+		}
+		labels := parseLabels(container.Labels)
+		tasks = append(tasks, &TaskInfo{
+			Id:         container.ID,
+			Name:       s.clientName(container.Names),
+			Image:      container.Image,
+			State:      container.State,
+			Status:     container.Status,
+			CreatedAt:  container.CreatedAt,
+			JobId:      labels["job-id"],
+			AgeSeconds: taskAge(container.CreatedAt, now),
+		})
+	}
+	return tasks, nil
+}
+
+// parseLabels parses docker's "key=value,key2=value2" label listing
+func parseLabels(labels string) map[string]string {
+	parsed := make(map[string]string)
+	for _, pair := range strings.Split(labels, ",") {
+		key, value, _ := strings.Cut(pair, "=")
+		if key != "" {
+			parsed[key] = value
+		}
+	}
+	return parsed
+}
@@ -0,0 +1,190 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultLogMaxSizeMb = 10
+	defaultLogMaxFiles  = 5
+)
+
+// validLogName matches docker container names and ids, which are safe to use as file names
+var validLogName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// RotatingFile is an io.Writer that rotates the file once it exceeds MaxSize bytes,
+// keeping at most MaxFiles rotated copies as path.1 (newest) .. path.N (oldest).
+// It is safe for concurrent use.
+type RotatingFile struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+func (r *RotatingFile) Write(p []byte) (n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err = r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (r *RotatingFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	for i := r.MaxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.Path, i), fmt.Sprintf("%s.%d", r.Path, i+1))
+	}
+	if r.MaxFiles > 0 {
+		if err := os.Rename(r.Path, r.Path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.Path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// logFiles returns the captured log files of a task, oldest first
+func (s *GrpcServer) logFiles(name string) []string {
+	base := filepath.Join(s.Config.Task.LogDir, name+".log")
+	var files []string
+	for i := s.logMaxFiles(); i >= 1; i-- {
+		rotated := fmt.Sprintf("%s.%d", base, i)
+		if _, err := os.Stat(rotated); err == nil {
+			files = append(files, rotated)
+		}
+	}
+	if _, err := os.Stat(base); err == nil {
+		files = append(files, base)
+	}
+	return files
+}
+
+func (s *GrpcServer) logMaxFiles() int {
+	if s.Config.Task.LogMaxFiles > 0 {
+		return s.Config.Task.LogMaxFiles
+	}
+	return defaultLogMaxFiles
+}
+
+// captureLogs follows the container logs into a rotating file under LogDir until the container exits.
+// ctx is the call that started the container, it only tags the log lines as the capture outlives it
+func (s *GrpcServer) captureLogs(ctx context.Context, containerID string, name string) {
+	if name == "" {
+		name = containerID
+	}
+	if err := os.MkdirAll(s.Config.Task.LogDir, 0755); err != nil {
+		logf(ctx, "Failed to create log dir %s: %v", s.Config.Task.LogDir, err)
+		return
+	}
+	maxSizeMb := s.Config.Task.LogMaxSizeMb
+	if maxSizeMb <= 0 {
+		maxSizeMb = defaultLogMaxSizeMb
+	}
+	writer := &RotatingFile{
+		Path:     filepath.Join(s.Config.Task.LogDir, name+".log"),
+		MaxSize:  maxSizeMb * 1024 * 1024,
+		MaxFiles: s.logMaxFiles(),
+	}
+	defer writer.Close()
+
+	if err := s.Docker.Stream(context.Background(), writer, writer, "logs", "-f", containerID); err != nil {
+		logf(ctx, "Log capture of %s ended: %v", name, err)
+	}
+}
+
+// StreamLogFile streams the logs captured on disk for a task, which outlive auto-removed containers
+func (s *GrpcServer) StreamLogFile(req *StreamLogsRequest, stream AgentService_StreamLogFileServer) error {
+	if s.Config.Task.LogDir == "" {
+		return status.Error(codes.FailedPrecondition, "Log capture is disabled on this agent")
+	}
+	if !validLogName.MatchString(req.Name) {
+		return status.Errorf(codes.InvalidArgument, "Invalid task name '%s'", req.Name)
+	}
+	files := s.logFiles(req.Name)
+	if len(files) == 0 {
+		return status.Errorf(codes.NotFound, "No captured logs for '%s'", req.Name)
+	}
+
+	buffer := make([]byte, 32*1024)
+	for _, fileName := range files {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to open log file: %v", err)
+		}
+		for {
+			n, err := file.Read(buffer)
+			if n > 0 {
+				data := make([]byte, n)
+				copy(data, buffer[:n])
+				if sendErr := stream.Send(&LogChunk{Data: data}); sendErr != nil {
+					_ = file.Close()
+					return sendErr
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				_ = file.Close()
+				return status.Errorf(codes.Internal, "Failed to read log file: %v", err)
+			}
+		}
+		_ = file.Close()
+	}
+	return nil
+}
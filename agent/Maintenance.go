@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maintenanceUntil is the epoch second a scheduled maintenance window ends, 0 when none is scheduled
+var maintenanceUntil atomic.Int64
+
+// SetMaintenanceUntil sets the end of the scheduled maintenance window, 0 clears it
+func SetMaintenanceUntil(until int64) {
+	maintenanceUntil.Store(until)
+}
+
+// MaintenanceUntil returns the end of the scheduled maintenance window, 0 when none is scheduled or it has passed
+func MaintenanceUntil() int64 {
+	until := maintenanceUntil.Load()
+	if until <= time.Now().Unix() {
+		return 0
+	}
+	return until
+}
+
+// SetMaintenance schedules or clears a maintenance window. Unlike Cordon new tasks are still accepted,
+// the window is reported with the heartbeat so the control plane can drain the node ahead of it
+func (s *GrpcServer) SetMaintenance(ctx context.Context, req *SetMaintenanceRequest) (*MaintenanceResponse, error) {
+	if req.Until < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Field 'until' must not be negative")
+	}
+	if req.Until != 0 && req.Until <= time.Now().Unix() {
+		return nil, status.Error(codes.InvalidArgument, "Field 'until' must be in the future")
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	SetMaintenanceUntil(req.Until)
+	s.Config.Server.MaintenanceUntil = req.Until
+	if err := s.Config.Save(""); err != nil {
+		return nil, status.Errorf(codes.Internal, "Maintenance window changed but could not be saved: %v", err)
+	}
+	message := "No maintenance scheduled"
+	if req.Until != 0 {
+		message = fmt.Sprintf("Maintenance scheduled until %s", time.Unix(req.Until, 0).Format(time.RFC3339))
+	}
+	return &MaintenanceResponse{MaintenanceUntil: req.Until, Message: message}, nil
+}
+
+// warnMaintenance logs a task accepted while a maintenance window is scheduled
+func warnMaintenance(ctx context.Context, req *StartTaskRequest) {
+	if until := MaintenanceUntil(); until != 0 {
+		logf(ctx, "WARNING: accepting task name=%s job=%s during maintenance scheduled until %s",
+			req.Name, req.Id, time.Unix(until, 0).Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PrefixLabel records the name prefix of the agent instance that launched a container
+const PrefixLabel = "cangling.prefix"
+
+// containerName maps a client supplied name to the docker container name
+func (s *GrpcServer) containerName(name string) string {
+	if name == "" {
+		return ""
+	}
+	return s.Config.Task.NamePrefix + name
+}
+
+// clientName maps a docker container name back to the name the client supplied
+func (s *GrpcServer) clientName(name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(name, "/"), s.Config.Task.NamePrefix)
+}
+
+// managedFilters selects the managed containers that belong to this agent instance
+func (s *GrpcServer) managedFilters() []string {
+	return managedFiltersFor(s.Config.Task.NamePrefix)
+}
+
+// managedFiltersFor selects the managed containers or volumes of the agent instance using namePrefix,
+// for the background work that has no server
+func managedFiltersFor(namePrefix string) []string {
+	filters := []string{"--filter", "label=" + ManagedLabel}
+	if namePrefix != "" {
+		filters = append(filters, "--filter", fmt.Sprintf("label=%s=%s", PrefixLabel, namePrefix))
+	}
+	return filters
+}
+
+// stripNamePrefix removes the name prefix from the NAMES column of `docker ps` table output
+func (s *GrpcServer) stripNamePrefix(output string) string {
+	prefix := s.Config.Task.NamePrefix
+	if prefix == "" {
+		return output
+	}
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		names := fields[len(fields)-1]
+		if strings.HasPrefix(names, prefix) {
+			lines[i] = line[:strings.LastIndex(line, names)] + s.clientName(names)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// taskTarget resolves the container addressed by a request, by job ID when given, else by name.
+// It returns the docker reference and the name to show in messages
+func (s *GrpcServer) taskTarget(ctx context.Context, name string, jobID string) (string, string, error) {
+	if jobID != "" {
+		containerID, err := s.findJob(ctx, jobID)
+		if err != nil {
+			return "", "", status.Errorf(codes.Internal, "Failed to look up job '%s': %v", jobID, err)
+		}
+		if containerID == "" {
+			return "", "", status.Errorf(codes.NotFound, "No container for job '%s'", jobID)
+		}
+		return containerID, jobID, nil
+	}
+	if name == "" {
+		return "", "", status.Error(codes.InvalidArgument, "Field 'name' or 'job_id' is required")
+	}
+	return s.containerName(name), name, nil
+}
+
+// maxNameSuffix bounds the suffixes tried by NAME_CONFLICT_SUFFIX
+const maxNameSuffix = 100
+
+// resolveNameConflict applies the request's on_name_conflict policy and returns the name to launch with
+func (s *GrpcServer) resolveNameConflict(ctx context.Context, req *StartTaskRequest) (string, error) {
+	if req.Name == "" || req.OnNameConflict == NameConflictPolicy_NAME_CONFLICT_FAIL {
+		return req.Name, nil
+	}
+	existing, err := s.existingContainer(ctx, s.containerName(req.Name))
+	if err != nil || existing == nil {
+		return req.Name, err
+	}
+
+	if req.OnNameConflict == NameConflictPolicy_NAME_CONFLICT_REPLACE {
+		managedKey, managedValue, _ := strings.Cut(ManagedLabel, "=")
+		if existing.Config.Labels[managedKey] != managedValue {
+			return "", reasonStatus(codes.FailedPrecondition, ReasonNameConflict,
+				fmt.Sprintf("Container '%s' was not started by this agent and is not replaced", req.Name))
+		}
+		logf(ctx, "Replacing container '%s' (%.12s)", req.Name, existing.Id)
+		_, _ = s.dockerOutput(ctx, "stop", existing.Id)
+		if _, err := s.dockerOutput(ctx, "rm", "-f", existing.Id); err != nil && !strings.Contains(err.Error(), "No such") {
+			return "", status.Errorf(codes.Internal, "Failed to remove container '%s': %v", req.Name, err)
+		}
+		return req.Name, nil
+	}
+
+	for suffix := 2; suffix <= maxNameSuffix; suffix++ {
+		name := fmt.Sprintf("%s-%d", req.Name, suffix)
+		existing, err := s.existingContainer(ctx, s.containerName(name))
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return name, nil
+		}
+	}
+	return "", reasonStatus(codes.AlreadyExists, ReasonNameConflict,
+		fmt.Sprintf("Names '%s' to '%s-%d' are all in use", req.Name, req.Name, maxNameSuffix))
+}
+
+// existingContainer inspects the container of that docker name, nil when there is none
+func (s *GrpcServer) existingContainer(ctx context.Context, containerName string) (*containerInspect, error) {
+	output, err := s.dockerOutput(ctx, "inspect", "--type", "container", containerName)
+	if err != nil {
+		if strings.Contains(err.Error(), "No such") {
+			return nil, nil
+		}
+		return nil, status.Errorf(codes.Internal, "Failed to look up container '%s': %v", containerName, err)
+	}
+	var inspected []containerInspect
+	if err := json.Unmarshal([]byte(output), &inspected); err != nil || len(inspected) == 0 {
+		return nil, status.Errorf(codes.Internal, "Unexpected docker inspect output: %v", err)
+	}
+	return &inspected[0], nil
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DockerInfo describes the docker daemon of this node
+type DockerInfo struct {
+	Version       string
+	StorageDriver string
+}
+
+var (
+	dockerInfoMutex  sync.Mutex
+	cachedDockerInfo *DockerInfo
+)
+
+// getDockerInfo returns the docker daemon description, collected once and cached.
+// A failed collection is retried on the next call.
+func getDockerInfo() DockerInfo {
+	dockerInfoMutex.Lock()
+	defer dockerInfoMutex.Unlock()
+	if cachedDockerInfo != nil {
+		return *cachedDockerInfo
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	version, err := dockerOutput(ctx, "version", "--format", "{{.Server.Version}}")
+	if err != nil {
+		log.Printf("Failed to read docker version: %v", err)
+		return DockerInfo{}
+	}
+	driver, err := dockerOutput(ctx, "info", "--format", "{{.Driver}}")
+	if err != nil {
+		log.Printf("Failed to read docker storage driver: %v", err)
+		return DockerInfo{}
+	}
+	cachedDockerInfo = &DockerInfo{
+		Version:       strings.TrimSpace(version),
+		StorageDriver: strings.TrimSpace(driver),
+	}
+	return *cachedDockerInfo
+}
+
+// checkDockerReady verifies the docker daemon answers, so a node that cannot run jobs is not reported online
+func checkDockerReady(ctx context.Context) error {
+	_, err := dockerOutput(ctx, "info", "--format", "{{.ServerVersion}}")
+	return err
+}
+
+// GetNodeInfo reports the node description and the recent usage history
+func (s *GrpcServer) GetNodeInfo(ctx context.Context, req *Empty) (*NodeInfoResponse, error) {
+	hostName, err := os.Hostname()
+	if err != nil {
+		hostName = ""
+	}
+	dockerInfo := getDockerInfo()
+	response := &NodeInfoResponse{
+		Version:       s.Version,
+		Hostname:      hostName,
+		Os:            runtime.GOOS,
+		Architecture:  runtime.GOARCH,
+		Memory:        getMemory(),
+		MemoryFree:    getMemoryFree(),
+		DockerVersion: dockerInfo.Version,
+		StorageDriver: dockerInfo.StorageDriver,
+		Usage:         usageHistory.list(),
+		AgentRuntime:  agentRuntime(),
+	}
+	return response, nil
+}
+
+// agentRuntime reports the memory and goroutines of this process, a cheap leak indicator
+func agentRuntime() *AgentRuntime {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	return &AgentRuntime{
+		Goroutines:     int32(runtime.NumGoroutine()),
+		HeapAllocBytes: memStats.HeapAlloc,
+		SysBytes:       memStats.Sys,
+		NumGc:          memStats.NumGC,
+		LastHeartbeat:  lastReportSuccess.Load(),
+	}
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// MutatingMethods are the RPCs that change containers on the node and are subject to rate limiting
+var MutatingMethods = []string{
+	AgentService_StartTask_FullMethodName,
+	AgentService_CreateTask_FullMethodName,
+	AgentService_StartCreatedTask_FullMethodName,
+	AgentService_UpdateTask_FullMethodName,
+	AgentService_CopyToTask_FullMethodName,
+	AgentService_ExecInteractive_FullMethodName,
+	AgentService_StopTask_FullMethodName,
+	AgentService_KillTask_FullMethodName,
+	AgentService_StopByLabel_FullMethodName,
+	AgentService_Cordon_FullMethodName,
+	AgentService_Uncordon_FullMethodName,
+	AgentService_SetMaintenance_FullMethodName,
+}
+
+// limiterIdleTimeout is how long a client's limiter is kept after its last call
+const limiterIdleTimeout = 10 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter throttles calls per client IP
+type RateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	methods map[string]bool
+
+	mutex   sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+// NewRateLimiter allows each client requestsPerSecond calls with bursts of up to burst calls to the given methods
+func NewRateLimiter(requestsPerSecond float64, burst int, methods []string) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	limited := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		limited[method] = true
+	}
+	return &RateLimiter{
+		limit:   rate.Limit(requestsPerSecond),
+		burst:   burst,
+		methods: limited,
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+// Allow reports whether a call from client may proceed
+func (r *RateLimiter) Allow(client string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	entry, ok := r.clients[client]
+	if !ok {
+		r.evictIdle(now)
+		entry = &clientLimiter{limiter: rate.NewLimiter(r.limit, r.burst)}
+		r.clients[client] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter.AllowN(now, 1)
+}
+
+func (r *RateLimiter) evictIdle(now time.Time) {
+	for client, entry := range r.clients {
+		if now.Sub(entry.lastSeen) > limiterIdleTimeout {
+			delete(r.clients, client)
+		}
+	}
+}
+
+// UnaryInterceptor rejects rate limited calls with codes.ResourceExhausted
+func (r *RateLimiter) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if r.methods[info.FullMethod] && !r.Allow(clientIP(ctx)) {
+		return nil, status.Errorf(codes.ResourceExhausted, "Rate limit exceeded for %s, retry later", info.FullMethod)
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor rejects rate limited streams, such as CopyToTask, with codes.ResourceExhausted
+func (r *RateLimiter) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if r.methods[info.FullMethod] && !r.Allow(clientIP(ss.Context())) {
+		return status.Errorf(codes.ResourceExhausted, "Rate limit exceeded for %s, retry later", info.FullMethod)
+	}
+	return handler(srv, ss)
+}
+
+// clientIP returns the IP of the calling peer, "" when unknown
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// fakeServerStream is a server stream that only carries a context
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (f *fakeServerStream) Context() context.Context {
+	return f.ctx
+}
+
+func peerContext(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+}
+
+func TestRateLimiterUnaryInterceptor(t *testing.T) {
+	limiter := NewRateLimiter(0.001, 2, MutatingMethods)
+	info := &grpc.UnaryServerInfo{FullMethod: AgentService_StartTask_FullMethodName}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.UnaryInterceptor(peerContext("10.0.0.1"), nil, info, handler); err != nil {
+			t.Fatalf("call %d within the burst: %v", i+1, err)
+		}
+	}
+	if _, err := limiter.UnaryInterceptor(peerContext("10.0.0.1"), nil, info, handler); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("call past the burst = %v, want ResourceExhausted", err)
+	}
+	if _, err := limiter.UnaryInterceptor(peerContext("10.0.0.2"), nil, info, handler); err != nil {
+		t.Errorf("another client was limited: %v", err)
+	}
+	readOnly := &grpc.UnaryServerInfo{FullMethod: AgentService_ListTasks_FullMethodName}
+	if _, err := limiter.UnaryInterceptor(peerContext("10.0.0.1"), nil, readOnly, handler); err != nil {
+		t.Errorf("unlimited method was limited: %v", err)
+	}
+}
+
+func TestRateLimiterStreamInterceptor(t *testing.T) {
+	limiter := NewRateLimiter(0.001, 2, MutatingMethods)
+	info := &grpc.StreamServerInfo{FullMethod: AgentService_CopyToTask_FullMethodName, IsClientStream: true}
+	stream := &fakeServerStream{ctx: peerContext("10.0.0.1")}
+	handled := 0
+	handler := func(srv any, ss grpc.ServerStream) error {
+		handled++
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := limiter.StreamInterceptor(nil, stream, info, handler); err != nil {
+			t.Fatalf("stream %d within the burst: %v", i+1, err)
+		}
+	}
+	if err := limiter.StreamInterceptor(nil, stream, info, handler); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("stream past the burst = %v, want ResourceExhausted", err)
+	}
+	if handled != 2 {
+		t.Errorf("handler ran %d times, want 2", handled)
+	}
+}
@@ -3,22 +3,35 @@ package agent
 import (
 	"CanglingAgent/config"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/pbnjay/memory"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type ApiResult struct {
-	Code    int         `json:"code"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data"`
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// RegisterResponse is the data of a successful registration
+type RegisterResponse struct {
+	Node *RegisteredNode `json:"node"`
+}
+
+type RegisteredNode struct {
+	Id string `json:"id"`
 }
 
 type Gpu struct {
@@ -44,16 +57,66 @@ type WorkNode struct {
 	StorageFree  uint64 `json:"storageFree"`
 	RunningPods  uint   `json:"runningPods"`
 	Online       bool   `json:"online"`
-	CreateTime   int64  `json:"createTime"`
-	OnlineTime   int64  `json:"onlineTime"`
-	Gpus         []Gpu  `json:"gpus"`
+	// NotReadyReason explains why the node is not Online
+	NotReadyReason string `json:"notReadyReason"`
+	// Times are Unix milliseconds. CreateTime is when the node registered, 0 if that predates its recording,
+	// OnlineTime is when this report was sent, StartTime is when the agent process started
+	// and BootTime is when the host booted, 0 if unknown
+	CreateTime    int64  `json:"createTime"`
+	OnlineTime    int64  `json:"onlineTime"`
+	StartTime     int64  `json:"startTime"`
+	BootTime      int64  `json:"bootTime"`
+	Gpus          []Gpu  `json:"gpus"`
+	DockerVersion string `json:"dockerVersion"`
+	StorageDriver string `json:"storageDriver"`
+	// CpuPercent and MemoryUsedMb aggregate all managed containers
+	CpuPercent   float64 `json:"cpuPercent"`
+	MemoryUsedMb uint64  `json:"memoryUsedMb"`
+	// ExitedTasks are the managed containers that finished since the last acknowledged report
+	ExitedTasks []ExitedTask      `json:"exitedTasks"`
+	Labels      map[string]string `json:"labels"`
+	// ReclaimedBytes is the disk space pruned by the janitor since the agent started
+	ReclaimedBytes uint64 `json:"reclaimedBytes"`
+	// Schedulable is false while the node is cordoned
+	Schedulable bool `json:"schedulable"`
+	// MaintenanceUntil is the epoch second a scheduled maintenance window ends, 0 when none is scheduled
+	MaintenanceUntil int64 `json:"maintenanceUntil"`
+	// AllocatableMemoryMb and AllocatableGpus are the capacity offered to tasks
+	AllocatableMemoryMb int64 `json:"allocatableMemoryMb"`
+	AllocatableGpus     int32 `json:"allocatableGpus"`
+	// Bench is the last result of the bench command, if it was saved
+	Bench *BenchResult `json:"bench,omitempty"`
+	// ReapedTasks are the containers the idle reaper stopped, FinishedAt being when
+	ReapedTasks []ExitedTask `json:"reapedTasks"`
+	// RestartCounts maps the job ID, or container name, of restarted managed containers to their restart count
+	RestartCounts map[string]int32 `json:"restartCounts,omitempty"`
+	// TaskRequests maps the job ID, or container name, of running managed containers to the resources requested at launch
+	TaskRequests map[string]TaskResources `json:"taskRequests,omitempty"`
+	// Images are the tagged images present on the node, refreshed every few minutes
+	Images []CachedImage `json:"images"`
+	// Interfaces lists every address of the node, InternalIp stays the primary one
+	Interfaces []NetInterface `json:"interfaces"`
+}
+
+// NetInterface is one address of an up, non-loopback interface. An interface with several addresses appears once per address
+type NetInterface struct {
+	Name string `json:"name"`
+	Ip   string `json:"ip"`
+	Mac  string `json:"mac"`
 }
 type RegisterRequest struct {
 	RegisterKey string   `json:"registerKey"`
 	Node        WorkNode `json:"node"`
 }
 
+// ReportAgentToServer sends the heartbeat of this node to the control plane
 func ReportAgentToServer(config config.Config, version string) error {
+	ctx, span := tracer.Start(context.Background(), "heartbeat")
+	defer span.End()
+	return traceErr(span, reportAgent(ctx, config, version))
+}
+
+func reportAgent(traceCtx context.Context, config config.Config, version string) error {
 	if config.Server.ServerUrl == "" {
 		return fmt.Errorf("this agent dose not have register to a server")
 	}
@@ -62,95 +125,197 @@ func ReportAgentToServer(config config.Config, version string) error {
 		hostName = ""
 	}
 
+	dockerInfo := getDockerInfo()
 	var request = RegisterRequest{
 		Node: WorkNode{
-			Id:           config.Server.AgentId,
-			Name:         hostName,
-			Memory:       getMemory(),
-			MemoryFree:   getMemoryFree(),
-			Online:       true,
-			Architecture: runtime.GOARCH,
-			Os:           runtime.GOOS,
-			AgentVersion: version,
+			Id:            config.Server.AgentId,
+			Name:          hostName,
+			Memory:        getMemory(),
+			MemoryFree:    getMemoryFree(),
+			Online:        true,
+			Architecture:  runtime.GOARCH,
+			Os:            runtime.GOOS,
+			AgentVersion:  version,
+			DockerVersion: dockerInfo.Version,
+			StorageDriver: dockerInfo.StorageDriver,
+			CreateTime:    config.Server.RegisteredAt,
+			OnlineTime:    time.Now().UnixMilli(),
+			StartTime:     agentStarted.UnixMilli(),
+			BootTime:      bootTime(),
 		},
 	}
+	ctx, cancel := context.WithTimeout(traceCtx, 5*time.Second)
+	defer cancel()
+	if err := checkDockerReady(ctx); err != nil {
+		request.Node.Online = false
+		request.Node.NotReadyReason = err.Error()
+	}
+	if usage, err := sampleUsage(ctx, config.Task.NamePrefix); err != nil {
+		log.Printf("Failed to sample container usage: %v", err)
+	} else {
+		request.Node.CpuPercent = usage.CpuPercent
+		request.Node.MemoryUsedMb = usage.MemoryUsedMb
+	}
+	request.Node.ExitedTasks = exitedTasks.list()
+	request.Node.ReapedTasks = reapedTasks.list()
+	request.Node.Labels = config.Server.EffectiveNodeLabels()
+	request.Node.ReclaimedBytes = reclaimedBytes.Load()
+	request.Node.Schedulable = !IsCordoned()
+	request.Node.MaintenanceUntil = MaintenanceUntil()
+	request.Node.AllocatableMemoryMb = allocatableMemoryMb(config.Server)
+	request.Node.AllocatableGpus = allocatableGpus(ctx, config.Server)
+	request.Node.Bench = loadBenchResult(config.Task.BenchResultFile)
+	request.Node.Interfaces = getInterfaces()
+	request.Node.Images = cachedImages.list(ctx, config.Server)
+	if inspected, err := inspectManaged(ctx, config.Task.NamePrefix); err != nil {
+		log.Printf("Failed to inspect managed containers: %v", err)
+	} else {
+		request.Node.RestartCounts = restartCounts(inspected)
+		request.Node.TaskRequests = taskRequests(inspected)
+	}
 	result := &ApiResult{}
-	err = postJSON(config.Server.ServerUrl, request, result)
+	err = postJSON(traceCtx, config.Server.ServerUrl, version, config.Server.AgentId, request, result)
 	if err != nil {
 		return err
 	}
 	if result.Code != 200 {
 		return fmt.Errorf("%s", result.Message)
 	}
+	lastReportSuccess.Store(time.Now().UnixMilli())
+	var response ReportResponse
+	if len(result.Data) > 0 && json.Unmarshal(result.Data, &response) == nil {
+		checkMinVersion(version, response.MinAgentVersion)
+	}
+	exitedTasks.acknowledge(request.Node.ExitedTasks)
+	reapedTasks.acknowledge(request.Node.ReapedTasks)
 	return nil
 }
 
 // Register an agent to the cangling server
 func Register(url string, token string, port int32, version string) (string, error) {
-	if url != "" && token != "" {
-		hostName, err := os.Hostname()
-		if err != nil {
-			return "", err
-		}
-		ip, err := getLocalIP()
-		if err != nil {
-			return "", err
-		}
-		var request = RegisterRequest{
-			RegisterKey: token,
-			Node: WorkNode{
-				Id:           "",
-				Name:         hostName,
-				InternalIp:   ip,
-				Port:         port,
-				Memory:       getMemory(),
-				MemoryFree:   getMemoryFree(),
-				Architecture: runtime.GOARCH,
-				Os:           runtime.GOOS,
-				AgentVersion: version,
-			},
-		}
-		result := &ApiResult{}
-		err = postJSON(url, request, result)
-		if err != nil {
-			return "", err
-		}
-		if result.Code != 200 {
-			return "", fmt.Errorf("%s", result.Message)
-		} else {
-			dataMap, ok := result.Data.(map[string]interface{})
-			if !ok {
-				fmt.Println("Error: Data field is not a map[string]interface{}")
-				return "", fmt.Errorf("%s", "没有返回数据")
-			}
-			nodeMap, ok := dataMap["node"].(map[string]interface{}) // JSON numbers default to float64
-			if !ok {
-				fmt.Println("return node is not a map[string]interface{}")
-				return "", fmt.Errorf("%s", "node data is not a map[string]interface{}")
-			}
-			nodeId, ok := nodeMap["id"].(string)
-			if !ok {
-				fmt.Println("node id is not a string")
-				return "", fmt.Errorf("%s", "node id is not a string")
-			}
-			return nodeId, nil
-		}
+	ctx, span := tracer.Start(context.Background(), "register")
+	defer span.End()
+	nodeId, err := register(ctx, url, token, port, version)
+	return nodeId, traceErr(span, err)
+}
 
-	} else {
+func register(ctx context.Context, url string, token string, port int32, version string) (string, error) {
+	if url == "" || token == "" {
 		return "", errors.New("url or token required")
 	}
-	return "", nil
+	hostName, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	ip, err := getLocalIP()
+	if err != nil {
+		return "", err
+	}
+	dockerInfo := getDockerInfo()
+	var request = RegisterRequest{
+		RegisterKey: token,
+		Node: WorkNode{
+			Id:            "",
+			Name:          hostName,
+			InternalIp:    ip,
+			Port:          port,
+			Memory:        getMemory(),
+			MemoryFree:    getMemoryFree(),
+			Architecture:  runtime.GOARCH,
+			Os:            runtime.GOOS,
+			AgentVersion:  version,
+			DockerVersion: dockerInfo.Version,
+			StorageDriver: dockerInfo.StorageDriver,
+			Interfaces:    getInterfaces(),
+			CreateTime:    time.Now().UnixMilli(),
+			OnlineTime:    time.Now().UnixMilli(),
+			StartTime:     agentStarted.UnixMilli(),
+			BootTime:      bootTime(),
+		},
+	}
+	result := &ApiResult{}
+	err = postJSON(ctx, url, version, "", request, result)
+	if err != nil {
+		return "", err
+	}
+	if result.Code != 200 {
+		return "", fmt.Errorf("%s", result.Message)
+	}
+	return parseRegisterResponse(result.Data)
+}
+
+// SaveRegistration records a registration made while the agent runs in the server's config and saves it.
+// The server's config is the copy cordon and maintenance changes save, so it must carry the registration
+func (s *GrpcServer) SaveRegistration(agentId string, serverUrl string, registeredAt int64) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	s.Config.Server.AgentId = agentId
+	s.Config.Server.ServerUrl = serverUrl
+	s.Config.Server.RegisteredAt = registeredAt
+	s.Config.Server.RegisterToken = ""
+	return s.Config.Save("")
+}
+
+// parseRegisterResponse extracts the node ID, naming the missing or mistyped field when the response is malformed
+func parseRegisterResponse(data json.RawMessage) (string, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return "", errors.New("registration response has no 'data'")
+	}
+	var response RegisterResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", fmt.Errorf("registration response 'data' is malformed: %v", err)
+	}
+	if response.Node == nil {
+		return "", errors.New("registration response has no 'data.node'")
+	}
+	if response.Node.Id == "" {
+		return "", errors.New("registration response has no 'data.node.id'")
+	}
+	return response.Node.Id, nil
 }
 
 func getMemory() uint64 {
 	return uint64(float64(memory.TotalMemory()) / 1024 / 1024 / 1024)
 }
 
+// getMemoryFree is the memory available to new workloads in GB. On Linux that is MemAvailable, which unlike
+// MemFree counts the page cache the kernel can reclaim, elsewhere the memory library's free figure
 func getMemoryFree() uint64 {
-	return uint64(float64(memory.FreeMemory()) / 1024 / 1024 / 1024)
+	freeBytes := memory.FreeMemory()
+	if data, err := os.ReadFile("/proc/meminfo"); err == nil {
+		if available, ok := parseMemAvailable(string(data)); ok {
+			freeBytes = available
+		}
+	}
+	return uint64(float64(freeBytes) / 1024 / 1024 / 1024)
+}
+
+// parseMemAvailable returns MemAvailable of /proc/meminfo in bytes, false on kernels before 3.14 that lack it
+func parseMemAvailable(meminfo string) (uint64, bool) {
+	for _, line := range strings.Split(meminfo, "\n") {
+		value, found := strings.CutPrefix(line, "MemAvailable:")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return 0, false
+		}
+		kilobytes, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return kilobytes * 1024, true
+	}
+	return 0, false
+}
+
+// userAgent identifies agent traffic in the control plane logs
+func userAgent(version string) string {
+	return fmt.Sprintf("CanglingAgent/%s (%s/%s)", version, runtime.GOOS, runtime.GOARCH)
 }
 
-func postJSON(url string, payload interface{}, result interface{}) error {
+func postJSON(ctx context.Context, url string, version string, agentId string, payload interface{}, result interface{}) error {
 	// Convert the payload struct to a JSON byte slice
 	requestBody, err := json.Marshal(payload)
 	if err != nil {
@@ -162,22 +327,22 @@ func postJSON(url string, payload interface{}, result interface{}) error {
 	// The http.Post function automatically sets the Content-Type header to "application/x-www-form-urlencoded"
 	// To explicitly set the Content-Type to "application/json", we should use http.NewRequest and http.Client.Do().
 
-	// --- Using http.Client for better control (recommended) ---
+	// --- Using the shared http.Client, configured with proxy settings ---
 
-	client := &http.Client{
-		Timeout: 10 * time.Second, // Set a timeout for the request
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return fmt.Errorf("error creating request: %w", err)
 	}
 
 	// Explicitly set the Content-Type header to indicate we are sending JSON
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent(version))
+	if agentId != "" {
+		req.Header.Set("X-Agent-Id", agentId)
+	}
 
 	// Execute the request
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("error sending request: %w", err)
 	}
@@ -248,3 +413,34 @@ func getLocalIP() (string, error) {
 
 	return "", fmt.Errorf("no suitable IP address found")
 }
+
+// getInterfaces lists the addresses of all up, non-loopback interfaces, skipping link-local ones
+func getInterfaces() []NetInterface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Printf("Failed to list network interfaces: %v", err)
+		return nil
+	}
+	var interfaces []NetInterface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			interfaces = append(interfaces, NetInterface{
+				Name: iface.Name,
+				Ip:   ipNet.IP.String(),
+				Mac:  iface.HardwareAddr.String(),
+			})
+		}
+	}
+	return interfaces
+}
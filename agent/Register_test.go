@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseRegisterResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr string
+	}{
+		{name: "node id", data: `{"node":{"id":"node-1"}}`, want: "node-1"},
+		{name: "missing data", data: ``, wantErr: "no 'data'"},
+		{name: "null data", data: `null`, wantErr: "no 'data'"},
+		{name: "missing node", data: `{}`, wantErr: "no 'data.node'"},
+		{name: "null node", data: `{"node":null}`, wantErr: "no 'data.node'"},
+		{name: "id of the wrong type", data: `{"node":{"id":42}}`, wantErr: "malformed"},
+		{name: "empty id", data: `{"node":{"id":""}}`, wantErr: "no 'data.node.id'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRegisterResponse(json.RawMessage(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseRegisterResponse error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRegisterResponse: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseRegisterResponse = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMemAvailable(t *testing.T) {
+	tests := []struct {
+		name    string
+		meminfo string
+		want    uint64
+		ok      bool
+	}{
+		{
+			name: "sample meminfo",
+			meminfo: "MemTotal:       16318480 kB\n" +
+				"MemFree:          813420 kB\n" +
+				"MemAvailable:    9876544 kB\n" +
+				"Buffers:          402112 kB\n",
+			want: 9876544 * 1024,
+			ok:   true,
+		},
+		{
+			name: "kernel before 3.14 without MemAvailable",
+			meminfo: "MemTotal:       16318480 kB\n" +
+				"MemFree:          813420 kB\n" +
+				"Buffers:          402112 kB\n",
+		},
+		{name: "malformed value", meminfo: "MemAvailable:    lots kB\n"},
+		{name: "missing value", meminfo: "MemAvailable:\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseMemAvailable(tt.meminfo)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("parseMemAvailable = %d, %v, want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
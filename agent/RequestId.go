@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIdHeader carries the correlation ID of a call, as gRPC metadata or HTTP header.
+// The agent echoes it in the response headers and generates one when the client sends none
+const RequestIdHeader = "x-request-id"
+
+type requestIdKey struct{}
+
+// RequestId returns the correlation ID of the call ctx belongs to, "" outside of a call
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// withRequestId stores the client's correlation ID in ctx, or a new one when it sent none
+func withRequestId(ctx context.Context) (context.Context, string) {
+	if id := RequestId(ctx); id != "" {
+		return ctx, id
+	}
+	var id string
+	if values := metadata.ValueFromIncomingContext(ctx, RequestIdHeader); len(values) > 0 && values[0] != "" {
+		id = values[0]
+	} else {
+		id = newRequestId()
+	}
+	return context.WithValue(ctx, requestIdKey{}, id), id
+}
+
+func newRequestId() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// logf logs a line of a call, tagged with its correlation ID
+func logf(ctx context.Context, format string, args ...any) {
+	if id := RequestId(ctx); id != "" {
+		format += " request_id=" + id
+	}
+	log.Printf(format, args...)
+}
+
+// UnaryRequestId attaches the correlation ID to the call and returns it in the response header.
+// It must be the first interceptor so the others log the ID
+func UnaryRequestId(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, id := withRequestId(ctx)
+	// Fails for calls from the REST gateway, which sets the HTTP header itself
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIdHeader, id))
+	return handler(ctx, req)
+}
+
+// StreamRequestId is UnaryRequestId for streaming calls
+func StreamRequestId(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, id := withRequestId(ss.Context())
+	_ = ss.SetHeader(metadata.Pairs(RequestIdHeader, id))
+	return handler(srv, &requestIdStream{ServerStream: ss, ctx: ctx})
+}
+
+type requestIdStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (r *requestIdStream) Context() context.Context {
+	return r.ctx
+}
+
+// httpRequestId returns the correlation ID of an HTTP request, generating one when it has none,
+// and echoes it in the response header
+func httpRequestId(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get(RequestIdHeader)
+	if id == "" {
+		id = newRequestId()
+		r.Header.Set(RequestIdHeader, id)
+	}
+	w.Header().Set(RequestIdHeader, id)
+	return id
+}
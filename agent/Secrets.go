@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// taskFiles are the agent side files a task needs, resolved and checked before launch
+type taskFiles struct {
+	envFiles []string
+	// secretMounts are ready --mount values
+	secretMounts []string
+	// seccompProfile is the resolved profile path or "unconfined"
+	seccompProfile string
+}
+
+// resolveSecrets maps the requested secrets to read-only bind mounts of files in the secrets directory.
+// Keep the directory on a tmpfs so the secrets never touch the disk
+func (s *GrpcServer) resolveSecrets(secrets []*SecretMount) ([]string, error) {
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+	if s.Config.Task.SecretDir == "" {
+		return nil, status.Error(codes.FailedPrecondition, "Secrets are not enabled on this agent")
+	}
+	mounts := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret.Name == "" || filepath.IsAbs(secret.Name) {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid secret name '%s'", secret.Name)
+		}
+		if !filepath.IsAbs(secret.MountPath) || strings.Contains(secret.MountPath, ",") {
+			return nil, status.Errorf(codes.InvalidArgument, "Secret mount path must be an absolute path without commas, got '%s'", secret.MountPath)
+		}
+		source, err := resolveWithin(s.Config.Task.SecretDir, secret.Name)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid secret: %v", err)
+		}
+		if strings.Contains(source, ",") {
+			return nil, status.Errorf(codes.InvalidArgument, "Secret '%s' resolves to a path with a comma", secret.Name)
+		}
+		mounts = append(mounts, fmt.Sprintf("type=bind,source=%s,target=%s,readonly", source, secret.MountPath))
+	}
+	return mounts, nil
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StopByLabel stops every managed container matching a label selector such as "experiment=42,stage=train"
+func (s *GrpcServer) StopByLabel(ctx context.Context, req *StopByLabelRequest) (*StopByLabelResponse, error) {
+	terms := selectorTerms(req.Selector)
+	// Refuse an empty selector, which would stop every managed container
+	if len(terms) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Field 'selector' is required")
+	}
+
+	containerIDs, err := s.selectContainers(ctx, terms, false)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to list containers: %v", err)
+	}
+
+	response := &StopByLabelResponse{}
+	for _, containerID := range containerIDs {
+		result := &StopResult{ContainerId: containerID}
+		if name, err := s.dockerOutput(ctx, "inspect", "--format", "{{.Name}}", containerID); err == nil {
+			result.Name = s.clientName(strings.TrimSpace(name))
+		}
+		if _, err := s.dockerOutput(ctx, "stop", containerID); err != nil {
+			result.Message = err.Error()
+		} else {
+			result.Stopped = true
+			result.Message = fmt.Sprintf("Container '%s' stopped successfully", result.Name)
+		}
+		response.Results = append(response.Results, result)
+	}
+	return response, nil
+}
+
+// selectorTerms splits a label selector into its "key" or "key=value" terms
+func selectorTerms(selector string) []string {
+	var terms []string
+	for _, term := range strings.Split(selector, ",") {
+		if term = strings.TrimSpace(term); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// selectContainers returns the IDs of the managed containers carrying all the label terms,
+// only running ones unless all is set
+func (s *GrpcServer) selectContainers(ctx context.Context, terms []string, all bool) ([]string, error) {
+	args := []string{"ps", "-q", "--no-trunc"}
+	if all {
+		args = append(args, "-a")
+	}
+	args = append(args, s.managedFilters()...)
+	for _, term := range terms {
+		args = append(args, "--filter", "label="+term)
+	}
+	output, err := s.dockerOutput(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
@@ -0,0 +1,24 @@
+package agent
+
+import (
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+// StreamTracker counts the streaming RPCs in flight, so shutdown can report the ones it cuts off
+type StreamTracker struct {
+	active atomic.Int64
+}
+
+// Active returns the number of streams currently open
+func (t *StreamTracker) Active() int64 {
+	return t.active.Load()
+}
+
+// StreamInterceptor keeps the count of open streams
+func (t *StreamTracker) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	t.active.Add(1)
+	defer t.active.Add(-1)
+	return handler(srv, ss)
+}
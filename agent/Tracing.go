@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"CanglingAgent/config"
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records the agent's own spans. Until InitTracing installs a provider it is a no-op
+var tracer = otel.Tracer("CanglingAgent/agent")
+
+// TracingEnabled reports whether spans are exported, the gRPC server, gateway and control plane
+// client are only instrumented then
+func TracingEnabled(serverConfig config.ServerConfig) bool {
+	return serverConfig.OtlpEndpoint != ""
+}
+
+// InitTracing exports spans to the configured OTLP/gRPC endpoint and accepts W3C trace context from
+// callers. The returned function flushes pending spans, it does nothing when tracing is disabled
+func InitTracing(ctx context.Context, serverConfig config.ServerConfig, version string) (func(context.Context) error, error) {
+	if !TracingEnabled(serverConfig) {
+		return func(context.Context) error { return nil }, nil
+	}
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(serverConfig.OtlpEndpoint)}
+	if serverConfig.OtlpInsecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "cangling-agent"),
+			attribute.String("service.version", version),
+			attribute.String("service.instance.id", serverConfig.AgentId),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// traceErr marks the span failed when err is set and returns err
+func traceErr(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validCpuset matches cpu lists such as "0-3" or "0,2,4-7"
+var validCpuset = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+
+// containerResources are the resource limits docker inspect reports under HostConfig
+type containerResources struct {
+	HostConfig struct {
+		CpuQuota   int64  `json:"CpuQuota"`
+		CpusetCpus string `json:"CpusetCpus"`
+		Memory     int64  `json:"Memory"`
+	} `json:"HostConfig"`
+}
+
+// UpdateTask changes the resource limits of a running container with docker update, without restarting it
+func (s *GrpcServer) UpdateTask(ctx context.Context, req *UpdateTaskRequest) (*UpdateTaskResponse, error) {
+	containerName, targetName, err := s.taskTarget(ctx, req.Name, req.JobId)
+	if err != nil {
+		return nil, err
+	}
+	if req.CpuQuota == 0 && req.CpusetCpus == "" && req.MemoryMb == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Nothing to update, set 'cpu_quota', 'cpuset_cpus' or 'memory_mb'")
+	}
+	if req.CpuQuota != 0 && req.CpuQuota != -1 && req.CpuQuota < 1000 {
+		return nil, status.Errorf(codes.InvalidArgument, "Field 'cpu_quota' must be -1 or at least 1000, got %d", req.CpuQuota)
+	}
+	if req.CpusetCpus != "" && !validCpuset.MatchString(req.CpusetCpus) {
+		return nil, status.Errorf(codes.InvalidArgument, "Field 'cpuset_cpus' must be a cpu list such as '0-3' or '0,2', got '%s'", req.CpusetCpus)
+	}
+	if req.MemoryMb < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Field 'memory_mb' must be positive, got %d", req.MemoryMb)
+	}
+
+	current, err := s.inspectResources(ctx, containerName)
+	if err != nil {
+		return nil, err
+	}
+	if req.MemoryMb > 0 {
+		// The container's current limit is already part of the reserved memory
+		reserved, err := s.reservedMemoryMb(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to compute reserved memory: %v", err)
+		}
+		reserved -= current.HostConfig.Memory / 1024 / 1024
+		allocatable := allocatableMemoryMb(s.Config.Server)
+		if reserved+int64(req.MemoryMb) > allocatable {
+			return nil, reasonStatus(codes.ResourceExhausted, ReasonResourceExhausted, fmt.Sprintf(
+				"Not enough allocatable memory: %dMB of %dMB reserved by other tasks, %dMB requested", reserved, allocatable, req.MemoryMb))
+		}
+	}
+
+	args := []string{"update"}
+	if req.CpuQuota != 0 {
+		args = append(args, "--cpu-quota", strconv.FormatInt(req.CpuQuota, 10))
+	}
+	if req.CpusetCpus != "" {
+		args = append(args, "--cpuset-cpus", req.CpusetCpus)
+	}
+	if req.MemoryMb > 0 {
+		args = append(args, "--memory", fmt.Sprintf("%dm", req.MemoryMb))
+	}
+	if _, err := s.dockerOutput(ctx, append(args, containerName)...); err != nil {
+		return nil, dockerStatus(fmt.Sprintf("Failed to update '%s': %v", targetName, err), err.Error())
+	}
+
+	applied, err := s.inspectResources(ctx, containerName)
+	if err != nil {
+		return nil, err
+	}
+	return &UpdateTaskResponse{
+		CpuQuota:   applied.HostConfig.CpuQuota,
+		CpusetCpus: applied.HostConfig.CpusetCpus,
+		MemoryMb:   int32(applied.HostConfig.Memory / 1024 / 1024),
+	}, nil
+}
+
+func (s *GrpcServer) inspectResources(ctx context.Context, containerName string) (*containerResources, error) {
+	output, err := s.dockerOutput(ctx, "inspect", "--type", "container", containerName)
+	if err != nil {
+		return nil, dockerStatus(fmt.Sprintf("Failed to inspect '%s': %v", containerName, err), err.Error())
+	}
+	var inspected []containerResources
+	if err := json.Unmarshal([]byte(output), &inspected); err != nil || len(inspected) == 0 {
+		return nil, status.Errorf(codes.Internal, "Unexpected docker inspect output: %v", err)
+	}
+	return &inspected[0], nil
+}
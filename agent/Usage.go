@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// usageHistorySize is the number of heartbeat samples kept for GetNodeInfo
+const usageHistorySize = 12
+
+// usageRing is a fixed size ring buffer of the latest samples, which are never modified once added
+type usageRing struct {
+	mutex   sync.Mutex
+	samples [usageHistorySize]*UsageSample
+	next    int
+	count   int
+}
+
+var usageHistory = &usageRing{}
+
+func (r *usageRing) add(sample *UsageSample) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % usageHistorySize
+	if r.count < usageHistorySize {
+		r.count++
+	}
+}
+
+// list returns the samples oldest first
+func (r *usageRing) list() []*UsageSample {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	samples := make([]*UsageSample, 0, r.count)
+	start := (r.next - r.count + usageHistorySize) % usageHistorySize
+	for i := 0; i < r.count; i++ {
+		samples = append(samples, r.samples[(start+i)%usageHistorySize])
+	}
+	return samples
+}
+
+// dockerStats is the subset of `docker stats --format "{{json .}}"` the agent aggregates
+type dockerStats struct {
+	CPUPerc  string `json:"CPUPerc"`
+	MemUsage string `json:"MemUsage"`
+}
+
+// sampleUsage collects the current usage of the managed containers of the agent using namePrefix
+// and records it in the history
+func sampleUsage(ctx context.Context, namePrefix string) (*UsageSample, error) {
+	sample := &UsageSample{Time: time.Now().UnixMilli()}
+	output, err := dockerOutput(ctx, append([]string{"ps", "-q"}, managedFiltersFor(namePrefix)...)...)
+	if err != nil {
+		return nil, err
+	}
+	ids := strings.Fields(output)
+	if len(ids) > 0 {
+		args := append([]string{"stats", "--no-stream", "--format", "{{json .}}"}, ids...)
+		output, err = dockerOutput(ctx, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(output, "\n") {
+			var stats dockerStats
+			if err := json.Unmarshal([]byte(line), &stats); err != nil {
+				continue
+			}
+			cpu, _ := strconv.ParseFloat(strings.TrimSuffix(stats.CPUPerc, "%"), 64)
+			used, _ := parseByteSize(strings.TrimSpace(strings.SplitN(stats.MemUsage, "/", 2)[0]))
+			sample.CpuPercent += cpu
+			sample.MemoryUsedMb += used / 1024 / 1024
+			sample.Containers++
+		}
+	}
+	usageHistory.add(sample)
+	return sample, nil
+}
+
+var byteUnits = map[string]float64{
+	"B":   1,
+	"kB":  1e3,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+// parseByteSize parses docker's human readable sizes such as "1.5GiB" or "512kB"
+func parseByteSize(size string) (uint64, error) {
+	number := strings.TrimRightFunc(size, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	multiplier, ok := byteUnits[strings.TrimSpace(size[len(number):])]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in size %q", size)
+	}
+	return uint64(value * multiplier), nil
+}
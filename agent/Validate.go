@@ -0,0 +1,298 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validUser matches the forms docker accepts for -u: name, uid, name:group or uid:gid
+var validUser = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*(:[a-zA-Z0-9_][a-zA-Z0-9_.-]*)?$`)
+
+// validHostname matches RFC 1123 host names
+var validHostname = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// validateRunOptions checks the optional docker run fields of a StartTaskRequest
+func validateRunOptions(req *StartTaskRequest) error {
+	if req.WorkingDir != "" && !path.IsAbs(req.WorkingDir) {
+		return status.Errorf(codes.InvalidArgument, "Field 'working_dir' must be an absolute path, got '%s'", req.WorkingDir)
+	}
+	if req.User != "" && !validUser.MatchString(req.User) {
+		return status.Errorf(codes.InvalidArgument, "Field 'user' must be a name or uid optionally followed by :group or :gid, got '%s'", req.User)
+	}
+	for _, mount := range req.Tmpfs {
+		mountPath := strings.SplitN(mount, ":", 2)[0]
+		if !path.IsAbs(mountPath) {
+			return status.Errorf(codes.InvalidArgument, "tmpfs path must be absolute, got '%s'", mount)
+		}
+	}
+	for _, alias := range req.HostAliases {
+		host, ip, found := strings.Cut(alias, ":")
+		if !found || !validHostname.MatchString(host) {
+			return status.Errorf(codes.InvalidArgument, "host alias must be 'hostname:ip', got '%s'", alias)
+		}
+		if ip != "host-gateway" && net.ParseIP(ip) == nil {
+			return status.Errorf(codes.InvalidArgument, "host alias '%s' has an invalid IP address", alias)
+		}
+	}
+	for _, server := range req.Dns {
+		if net.ParseIP(server) == nil {
+			return status.Errorf(codes.InvalidArgument, "DNS server must be an IP address, got '%s'", server)
+		}
+	}
+	for _, domain := range req.DnsSearch {
+		if domain != "." && !validHostname.MatchString(domain) {
+			return status.Errorf(codes.InvalidArgument, "Invalid DNS search domain '%s'", domain)
+		}
+	}
+	for _, ulimit := range req.Ulimits {
+		if err := validateUlimit(ulimit); err != nil {
+			return err
+		}
+	}
+	for _, device := range req.Devices {
+		if err := validateDevice(device); err != nil {
+			return err
+		}
+	}
+	for _, mount := range req.VolumeMounts {
+		if err := validateVolumeMount(mount); err != nil {
+			return err
+		}
+	}
+	if req.StopSignal != "" && !knownSignal(req.StopSignal) {
+		return status.Errorf(codes.InvalidArgument, "Unknown stop signal '%s'", req.StopSignal)
+	}
+	if req.MemorySwapMb != 0 {
+		if req.MemoryMb <= 0 {
+			return status.Error(codes.InvalidArgument, "Field 'memory_swap_mb' needs 'memory_mb'")
+		}
+		if req.MemorySwapMb != -1 && req.MemorySwapMb < int64(req.MemoryMb) {
+			return status.Errorf(codes.InvalidArgument, "Field 'memory_swap_mb' must be -1 or at least memory_mb (%d), got %d",
+				req.MemoryMb, req.MemorySwapMb)
+		}
+	}
+	if req.IdleTimeoutMinutes < 0 {
+		return status.Errorf(codes.InvalidArgument, "Field 'idle_timeout_minutes' must be positive, got %d", req.IdleTimeoutMinutes)
+	}
+	if req.StopTimeoutSeconds < 0 {
+		return status.Errorf(codes.InvalidArgument, "Field 'stop_timeout_seconds' must be positive, got %d", req.StopTimeoutSeconds)
+	}
+	for _, capability := range append(append([]string{}, req.CapAdd...), req.CapDrop...) {
+		if !knownCapability(capability) {
+			return status.Errorf(codes.InvalidArgument, "Unknown capability '%s'", capability)
+		}
+	}
+	if req.ApparmorProfile != "" && !validApparmorProfile.MatchString(req.ApparmorProfile) {
+		return status.Errorf(codes.InvalidArgument, "Invalid apparmor profile '%s'", req.ApparmorProfile)
+	}
+	if req.LogDriver != "" && !logDrivers[req.LogDriver] {
+		return status.Errorf(codes.InvalidArgument, "Unknown log driver '%s'", req.LogDriver)
+	}
+	if len(req.LogOpts) > 0 && req.LogDriver == "" {
+		return status.Error(codes.InvalidArgument, "Field 'log_opts' needs 'log_driver'")
+	}
+	for key := range req.LogOpts {
+		if key == "" || strings.ContainsAny(key, "= ") {
+			return status.Errorf(codes.InvalidArgument, "Invalid log option '%s'", key)
+		}
+	}
+	return nil
+}
+
+// logDrivers are the log drivers shipped with docker
+var logDrivers = map[string]bool{
+	"json-file": true, "local": true, "journald": true, "syslog": true, "fluentd": true,
+	"gelf": true, "awslogs": true, "splunk": true, "gcplogs": true, "etwlogs": true, "none": true,
+}
+
+// checkDevicesAllowed rejects device mappings whose host path is not one of the allowed devices or below it
+func checkDevicesAllowed(devices []string, allowed []string) error {
+	for _, device := range devices {
+		hostPath := path.Clean(strings.SplitN(device, ":", 2)[0])
+		if !slices.ContainsFunc(allowed, func(entry string) bool {
+			entry = path.Clean(entry)
+			return hostPath == entry || strings.HasPrefix(hostPath, strings.TrimSuffix(entry, "/")+"/")
+		}) {
+			return status.Errorf(codes.FailedPrecondition, "Device '%s' is not in the allowed devices of this agent", hostPath)
+		}
+	}
+	return nil
+}
+
+// validateDevice checks a "host[:container][:permissions]" device mapping
+func validateDevice(device string) error {
+	parts := strings.Split(device, ":")
+	if len(parts) > 3 {
+		return status.Errorf(codes.InvalidArgument, "device must be 'host[:container][:rwm]', got '%s'", device)
+	}
+	hostPath := parts[0]
+	containerPath := ""
+	permissions := "rwm"
+	switch {
+	case len(parts) == 3:
+		containerPath, permissions = parts[1], parts[2]
+	case len(parts) == 2 && strings.HasPrefix(parts[1], "/"):
+		containerPath = parts[1]
+	case len(parts) == 2:
+		permissions = parts[1]
+	}
+
+	if !path.IsAbs(hostPath) {
+		return status.Errorf(codes.InvalidArgument, "device '%s' host path must be absolute", device)
+	}
+	if len(parts) == 3 && !path.IsAbs(containerPath) {
+		return status.Errorf(codes.InvalidArgument, "device '%s' container path must be absolute", device)
+	}
+	if permissions == "" || strings.Trim(permissions, "rwm") != "" {
+		return status.Errorf(codes.InvalidArgument, "device '%s' permissions must be a subset of 'rwm'", device)
+	}
+	return nil
+}
+
+// checkDevicesExist rejects device mappings whose host path is missing on the node. It runs after
+// checkDevicesAllowed so that clients cannot probe paths outside the allowed devices
+func checkDevicesExist(devices []string) error {
+	for _, device := range devices {
+		hostPath := strings.SplitN(device, ":", 2)[0]
+		if _, err := os.Stat(hostPath); err != nil {
+			return status.Errorf(codes.InvalidArgument, "device '%s' does not exist on this node", hostPath)
+		}
+	}
+	return nil
+}
+
+// ulimitNames are the resource limits docker accepts for --ulimit
+var ulimitNames = map[string]bool{
+	"core": true, "cpu": true, "data": true, "fsize": true, "locks": true,
+	"memlock": true, "msgqueue": true, "nice": true, "nofile": true, "nproc": true,
+	"rss": true, "rtprio": true, "rttime": true, "sigpending": true, "stack": true,
+}
+
+// validateUlimit checks a "name=soft:hard" or "name=limit" ulimit, -1 meaning unlimited
+func validateUlimit(ulimit string) error {
+	name, limits, found := strings.Cut(ulimit, "=")
+	if !found || !ulimitNames[name] {
+		return status.Errorf(codes.InvalidArgument, "ulimit must be 'name=soft:hard' with a known name, got '%s'", ulimit)
+	}
+	softText, hardText, hasHard := strings.Cut(limits, ":")
+	if !hasHard {
+		hardText = softText
+	}
+	soft, softErr := strconv.ParseInt(softText, 10, 64)
+	hard, hardErr := strconv.ParseInt(hardText, 10, 64)
+	if softErr != nil || hardErr != nil || soft < -1 || hard < -1 {
+		return status.Errorf(codes.InvalidArgument, "ulimit '%s' limits must be integers or -1", ulimit)
+	}
+	if hard != -1 && (soft == -1 || soft > hard) {
+		return status.Errorf(codes.InvalidArgument, "ulimit '%s' soft limit exceeds the hard limit", ulimit)
+	}
+	return nil
+}
+
+// resolveWithin resolves name relative to dir and makes sure the result, symlinks followed,
+// is an existing file inside dir
+func resolveWithin(dir string, name string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("no allowed directory is configured")
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("allowed directory %s is not accessible: %v", dir, err)
+	}
+	target := name
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(root, target)
+	}
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", fmt.Errorf("'%s' does not exist", name)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' is outside of %s", name, dir)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil || info.IsDir() {
+		return "", fmt.Errorf("'%s' is not a file", name)
+	}
+	return resolved, nil
+}
+
+// validApparmorProfile matches the names of loaded apparmor profiles, such as docker-default
+var validApparmorProfile = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validVolumeName matches the names docker accepts for named volumes
+var validVolumeName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validateVolumeMount checks a typed mount, filling in the type from the source when it is empty
+func validateVolumeMount(mount *VolumeMount) error {
+	if mount.Type == "" {
+		mount.Type = "volume"
+		if path.IsAbs(mount.Source) {
+			mount.Type = "bind"
+		}
+	}
+	if !path.IsAbs(mount.Target) || strings.Contains(mount.Target, ",") {
+		return status.Errorf(codes.InvalidArgument, "Volume target must be an absolute path without commas, got '%s'", mount.Target)
+	}
+	switch mount.Type {
+	case "bind":
+		if !path.IsAbs(mount.Source) || strings.Contains(mount.Source, ",") {
+			return status.Errorf(codes.InvalidArgument, "Bind mount source must be an absolute path without commas, got '%s'", mount.Source)
+		}
+	case "volume":
+		// An empty source asks for an anonymous volume
+		if mount.Source != "" && !validVolumeName.MatchString(mount.Source) {
+			return status.Errorf(codes.InvalidArgument, "Invalid volume name '%s'", mount.Source)
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "Volume type must be 'bind' or 'volume', got '%s'", mount.Type)
+	}
+	return nil
+}
+
+// signalNames are the Linux signals, without the SIG prefix
+var signalNames = map[string]bool{
+	"HUP": true, "INT": true, "QUIT": true, "ILL": true, "TRAP": true, "ABRT": true, "BUS": true,
+	"FPE": true, "KILL": true, "USR1": true, "SEGV": true, "USR2": true, "PIPE": true, "ALRM": true,
+	"TERM": true, "STKFLT": true, "CHLD": true, "CONT": true, "STOP": true, "TSTP": true, "TTIN": true,
+	"TTOU": true, "URG": true, "XCPU": true, "XFSZ": true, "VTALRM": true, "PROF": true, "WINCH": true,
+	"IO": true, "PWR": true, "SYS": true,
+}
+
+// knownSignal accepts a signal name with or without SIG, or a signal number
+func knownSignal(signal string) bool {
+	if number, err := strconv.Atoi(signal); err == nil {
+		return number > 0 && number <= 64
+	}
+	return signalNames[strings.TrimPrefix(strings.ToUpper(signal), "SIG")]
+}
+
+// capabilityNames are the Linux capabilities, without the CAP_ prefix
+var capabilityNames = map[string]bool{
+	"CHOWN": true, "DAC_OVERRIDE": true, "DAC_READ_SEARCH": true, "FOWNER": true, "FSETID": true,
+	"KILL": true, "SETGID": true, "SETUID": true, "SETPCAP": true, "LINUX_IMMUTABLE": true,
+	"NET_BIND_SERVICE": true, "NET_BROADCAST": true, "NET_ADMIN": true, "NET_RAW": true,
+	"IPC_LOCK": true, "IPC_OWNER": true, "SYS_MODULE": true, "SYS_RAWIO": true, "SYS_CHROOT": true,
+	"SYS_PTRACE": true, "SYS_PACCT": true, "SYS_ADMIN": true, "SYS_BOOT": true, "SYS_NICE": true,
+	"SYS_RESOURCE": true, "SYS_TIME": true, "SYS_TTY_CONFIG": true, "MKNOD": true, "LEASE": true,
+	"AUDIT_WRITE": true, "AUDIT_CONTROL": true, "SETFCAP": true, "MAC_OVERRIDE": true, "MAC_ADMIN": true,
+	"SYSLOG": true, "WAKE_ALARM": true, "BLOCK_SUSPEND": true, "AUDIT_READ": true, "PERFMON": true,
+	"BPF": true, "CHECKPOINT_RESTORE": true,
+}
+
+// knownCapability accepts a capability name with or without CAP_, or ALL
+func knownCapability(capability string) bool {
+	name := strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+	return name == "ALL" || capabilityNames[name]
+}
@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckDevicesAllowed(t *testing.T) {
+	allowed := []string{"/dev/fuse", "/dev/dri/"}
+	tests := []struct {
+		name    string
+		devices []string
+		allowed []string
+		code    codes.Code
+	}{
+		{name: "no devices", allowed: nil},
+		{name: "empty list refuses devices", devices: []string{"/dev/fuse"}, code: codes.FailedPrecondition},
+		{name: "exact", devices: []string{"/dev/fuse:/dev/fuse:rwm"}, allowed: allowed},
+		{name: "below an allowed directory", devices: []string{"/dev/dri/card0", "/dev/dri/renderD128:/dev/dri/renderD128"}, allowed: allowed},
+		{name: "sibling sharing the prefix", devices: []string{"/dev/fuse0"}, allowed: allowed, code: codes.FailedPrecondition},
+		{name: "escaping with dot-dot", devices: []string{"/dev/dri/../sda"}, allowed: allowed, code: codes.FailedPrecondition},
+		{name: "one device outside the list", devices: []string{"/dev/fuse", "/dev/sda"}, allowed: allowed, code: codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkDevicesAllowed(tt.devices, tt.allowed); status.Code(err) != tt.code {
+				t.Errorf("checkDevicesAllowed(%q, %q) = %v, want code %v", tt.devices, tt.allowed, err, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/mod/semver"
+)
+
+// ReportResponse is the data the control plane may return for a heartbeat
+type ReportResponse struct {
+	// MinAgentVersion is the oldest agent version the control plane supports
+	MinAgentVersion string `json:"minAgentVersion"`
+}
+
+// warnedMinVersion is the last minimum version warned about, so the warning is not repeated every heartbeat
+var warnedMinVersion atomic.Value
+
+// canonicalVersion turns "1.0.15" or "v1.0.15" into the "v1.0.15" form semver expects, empty when invalid
+func canonicalVersion(version string) string {
+	version = strings.TrimSpace(version)
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return semver.Canonical(version)
+}
+
+// versionSatisfies reports whether version is at least minimum
+func versionSatisfies(version string, minimum string) (bool, error) {
+	current, required := canonicalVersion(version), canonicalVersion(minimum)
+	if current == "" {
+		return false, fmt.Errorf("invalid agent version '%s'", version)
+	}
+	if required == "" {
+		return false, fmt.Errorf("invalid minimum version '%s'", minimum)
+	}
+	return semver.Compare(current, required) >= 0, nil
+}
+
+// checkMinVersion warns, once per minimum version, when the control plane requires a newer agent
+func checkMinVersion(version string, minimum string) {
+	if minimum == "" || warnedMinVersion.Load() == minimum {
+		return
+	}
+	ok, err := versionSatisfies(version, minimum)
+	if err != nil {
+		log.Printf("Cannot check the version required by the server: %v", err)
+	} else if !ok {
+		log.Printf("WARNING: the server requires agent version %s or newer, this agent is %s. Please upgrade", minimum, version)
+	}
+	warnedMinVersion.Store(minimum)
+}
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"github.com/pelletier/go-toml/v2"
 	"log"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 // ServerConfig Config all config information can be read or wrote to a file config.toml
@@ -17,13 +19,230 @@ import (
 // [repository]
 // root
 type ServerConfig struct {
-	Port      int32  `toml:"port"`
-	AgentId   string `toml:"agentId"`
-	ServerUrl string `toml:"serverUrl"`
+	Port int32 `toml:"port"`
+	// BindAddress is the IP the gRPC server listens on, all interfaces when empty
+	BindAddress string `toml:"bindAddress"`
+	AgentId     string `toml:"agentId"`
+	ServerUrl   string `toml:"serverUrl"`
+	// RegisteredAt is the Unix time in milliseconds the register command succeeded, reported as the node's createTime
+	RegisteredAt int64 `toml:"registeredAt"`
+	// RegisterToken makes an agent without AgentId register itself to ServerUrl on startup, like the register command.
+	// It is removed from the config once the registration succeeded. See also RegisterTokenEnv and ServerUrlEnv
+	RegisterToken string `toml:"registerToken"`
+	// AllowRemoteConfig lets clients other than localhost read the redacted config with GetConfig
+	AllowRemoteConfig bool `toml:"allowRemoteConfig"`
+	// GatewayAddr serves the AgentService over REST/JSON on the /api/v1 paths when not empty, e.g. "127.0.0.1:8080"
+	GatewayAddr string `toml:"gatewayAddr"`
+	// GatewayMaxBodyKb bounds the body of a REST gateway request, 1024 when unset. Larger bodies get 413
+	GatewayMaxBodyKb int `toml:"gatewayMaxBodyKb"`
+	// OtlpEndpoint is the host:port of an OTLP/gRPC collector spans are exported to, tracing is off when empty
+	OtlpEndpoint string `toml:"otlpEndpoint"`
+	// OtlpInsecure exports spans without TLS, for a collector on the node or a trusted network
+	OtlpInsecure bool `toml:"otlpInsecure"`
+	// PprofAddr enables the net/http/pprof debug server when not empty, e.g. "127.0.0.1:6060"
+	PprofAddr string `toml:"pprofAddr"`
+	// RateLimit is the number of start/stop calls per second allowed per client IP, unlimited when 0
+	RateLimit float64 `toml:"rateLimit"`
+	// RateBurst is the number of start/stop calls a client may burst above RateLimit
+	RateBurst int `toml:"rateBurst"`
+	// DisableReflection turns off gRPC server reflection. Reflection exposes the full service
+	// schema to anyone who can connect and should be disabled in production
+	DisableReflection bool `toml:"disableReflection"`
+	// KeepaliveTimeSeconds is the idle time after which the server pings a client, 60 when unset
+	KeepaliveTimeSeconds int `toml:"keepaliveTimeSeconds"`
+	// KeepaliveTimeoutSeconds is how long to wait for a ping ack before closing the connection, 20 when unset
+	KeepaliveTimeoutSeconds int `toml:"keepaliveTimeoutSeconds"`
+	// KeepaliveMinClientSeconds is the shortest ping interval allowed from clients, 30 when unset
+	KeepaliveMinClientSeconds int `toml:"keepaliveMinClientSeconds"`
+	// ShutdownTimeoutSeconds is how long shutdown waits for open streams before closing them, 10 when unset
+	ShutdownTimeoutSeconds int `toml:"shutdownTimeoutSeconds"`
+	// MaxRecvMsgSizeMb and MaxSendMsgSizeMb bound a single gRPC message, 16 when unset instead of gRPC's 4MB.
+	// Clients receiving large ListTasks responses or log chunks must raise their own receive limit to match
+	MaxRecvMsgSizeMb int `toml:"maxRecvMsgSizeMb"`
+	MaxSendMsgSizeMb int `toml:"maxSendMsgSizeMb"`
+	// Cordoned stops the node from accepting new tasks, see the cordon command
+	Cordoned bool `toml:"cordoned"`
+	// MaintenanceUntil is the epoch second a scheduled maintenance window ends, see the maintenance command
+	MaintenanceUntil int64 `toml:"maintenanceUntil"`
+	// HttpProxy, HttpsProxy and NoProxy configure the proxy used to reach the control plane,
+	// the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment is used when all are empty
+	HttpProxy  string `toml:"httpProxy"`
+	HttpsProxy string `toml:"httpsProxy"`
+	NoProxy    string `toml:"noProxy"`
+	// ServerCaFile is a PEM bundle of extra CAs trusted for the control plane, e.g. a private CA
+	ServerCaFile string `toml:"serverCaFile"`
+	// InsecureSkipVerify disables control plane certificate verification, for testing only
+	InsecureSkipVerify bool `toml:"insecureSkipVerify"`
+	// ClientCertFile and ClientKeyFile are the PEM client certificate presented to an mTLS control plane
+	ClientCertFile string `toml:"clientCertFile"`
+	ClientKeyFile  string `toml:"clientKeyFile"`
+	// AllocatableMemoryMb is the memory tasks may reserve, leaving headroom for the OS. Physical memory when 0
+	AllocatableMemoryMb int64 `toml:"allocatableMemoryMb"`
+	// AllocatableGpus is the number of GPUs tasks may reserve. All installed GPUs when 0.
+	// Both limits count only the tasks of this agent's namePrefix, agents sharing a node should split them
+	AllocatableGpus int32 `toml:"allocatableGpus"`
+	// HeartbeatJitterPercent randomly shifts each heartbeat by up to this share of the interval, 10 when unset, negative disables it
+	HeartbeatJitterPercent int `toml:"heartbeatJitterPercent"`
+	// ReportFailureThreshold is the number of heartbeats in a row that may fail before ReportFailureAction is taken,
+	// 0 never acts
+	ReportFailureThreshold int `toml:"reportFailureThreshold"`
+	// ReportFailureAction is "log" (default), "cordon" to refuse new tasks until a heartbeat succeeds again,
+	// which leaves a cordon set by the cordon command in place,
+	// or "exit" so an orchestrator restarts the agent
+	ReportFailureAction string `toml:"reportFailureAction"`
+	// ImageRefreshMinutes is how often the image list reported in the heartbeat is refreshed, 5 when unset
+	ImageRefreshMinutes int `toml:"imageRefreshMinutes"`
+	// MaxReportedImages caps the images reported in the heartbeat, newest first, 200 when unset
+	MaxReportedImages int `toml:"maxReportedImages"`
+	// NodeLabels are reported in the heartbeat for label based node selection, e.g. gpu = "a100"
+	NodeLabels map[string]string `toml:"nodeLabels"`
+}
+
+// NodeLabelsEnv overrides or adds node labels, formatted as "key=value,key2=value2"
+const NodeLabelsEnv = "CANGLING_NODE_LABELS"
+
+// RegisterTokenEnv and ServerUrlEnv supply RegisterToken and ServerUrl when the config leaves them empty,
+// so provisioning can register new agents without writing a config file
+const (
+	RegisterTokenEnv = "CANGLING_REGISTER_TOKEN"
+	ServerUrlEnv     = "CANGLING_SERVER_URL"
+)
+
+// AutoRegistration returns the server url and token to register with on startup, empty when the agent
+// is already registered or no token is configured
+func (s ServerConfig) AutoRegistration() (string, string) {
+	if s.AgentId != "" {
+		return "", ""
+	}
+	serverUrl, token := s.ServerUrl, s.RegisterToken
+	if serverUrl == "" {
+		serverUrl = os.Getenv(ServerUrlEnv)
+	}
+	if token == "" {
+		token = os.Getenv(RegisterTokenEnv)
+	}
+	if serverUrl == "" || token == "" {
+		return "", ""
+	}
+	return serverUrl, token
+}
+
+// EffectiveNodeLabels merges the labels from NodeLabelsEnv over the configured NodeLabels
+func (s ServerConfig) EffectiveNodeLabels() map[string]string {
+	labels := make(map[string]string, len(s.NodeLabels))
+	for key, value := range s.NodeLabels {
+		labels[key] = value
+	}
+	for _, pair := range strings.Split(os.Getenv(NodeLabelsEnv), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if key != "" {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// TaskConfig controls how the agent launches task containers
+// [task]
+// rejectBusyGpus = true
+type TaskConfig struct {
+	// RejectBusyGpus refuses a StartTask whose GPUs are held by another managed job of this agent's namePrefix
+	RejectBusyGpus bool `toml:"rejectBusyGpus"`
+	// NamePrefix is prepended to every container name, so agents sharing a node do not collide
+	NamePrefix string `toml:"namePrefix"`
+	// LogDir enables capturing container logs to rotating files in this directory
+	LogDir string `toml:"logDir"`
+	// LogMaxSizeMb is the size a log file is rotated at, 10 when unset
+	LogMaxSizeMb int64 `toml:"logMaxSizeMb"`
+	// LogMaxFiles is the number of rotated log files kept, 5 when unset
+	LogMaxFiles int `toml:"logMaxFiles"`
+	// AllowedRegistries limits the registries images are pulled from, e.g. ["docker.io", "hub.cangling.cn"]. Empty allows all
+	AllowedRegistries []string `toml:"allowedRegistries"`
+	// AllowedImages limits the images tasks may run. Plain entries match as a prefix, e.g. "hub.cangling.cn/ml/",
+	// entries with *, ? or [ match as a glob, e.g. "docker.io/library/python:3.*". Empty allows all
+	AllowedImages []string `toml:"allowedImages"`
+	// AllowedDevices are the host devices tasks may map, e.g. ["/dev/fuse", "/dev/dri"]. An entry also allows
+	// the devices below it, so "/dev/dri" allows "/dev/dri/card0". Devices are refused when empty
+	AllowedDevices []string `toml:"allowedDevices"`
+	// RedactEnvPattern is a regexp of env names whose values InspectTask hides, TOKEN/SECRET/PASSWORD/KEY when unset
+	RedactEnvPattern string `toml:"redactEnvPattern"`
+	// StopVerifySeconds is how long StopTask waits for a stopped container to go away before killing it, 10 when unset
+	StopVerifySeconds int `toml:"stopVerifySeconds"`
+	// EnvFileDir is the only directory StartTask env files may be read from, env files are refused when empty
+	EnvFileDir string `toml:"envFileDir"`
+	// SecretDir is the only directory task secrets may be mounted from, secrets are refused when empty.
+	// Keep it on a tmpfs such as /run so secrets stay in memory
+	SecretDir string `toml:"secretDir"`
+	// SecurityProfileDir is the only directory seccomp profiles may be read from, profile files are refused when empty
+	SecurityProfileDir string `toml:"securityProfileDir"`
+	// RunRetries is how often a docker run failing with a transient error, such as a registry
+	// rate limit, is retried. Failures are not retried when 0
+	RunRetries int `toml:"runRetries"`
+	// RunRetryBackoffSeconds is the delay before the first retry, doubling after each, 2 when unset
+	RunRetryBackoffSeconds int `toml:"runRetryBackoffSeconds"`
+	// BenchImage is the CPU benchmark image of the bench command, a built-in busybox script when empty
+	BenchImage string `toml:"benchImage"`
+	// BenchGpuImage is the GPU benchmark image, the GPU benchmark is skipped when empty
+	BenchGpuImage string `toml:"benchGpuImage"`
+	// BenchSeconds is how long each benchmark runs, 10 when unset
+	BenchSeconds int `toml:"benchSeconds"`
+	// BenchResultFile is where the bench command saves its result, reported in the heartbeat when set
+	BenchResultFile string `toml:"benchResultFile"`
+	// JanitorEnabled turns on periodic pruning of exited managed containers
+	JanitorEnabled bool `toml:"janitorEnabled"`
+	// JanitorIntervalMinutes is the time between prunes, 60 when unset
+	JanitorIntervalMinutes int `toml:"janitorIntervalMinutes"`
+	// JanitorMaxAgeHours is the age an exited container must reach before it is pruned, 24 when unset
+	JanitorMaxAgeHours int `toml:"janitorMaxAgeHours"`
+	// JanitorPruneImages also removes dangling images
+	JanitorPruneImages bool `toml:"janitorPruneImages"`
+	// JanitorPruneVolumes also removes the unused volumes carrying the agent's labels, named ones included.
+	// Needs Docker 23 or later for volume prune --all
+	JanitorPruneVolumes bool `toml:"janitorPruneVolumes"`
+	// AllowExtraArgs lets tasks pass arbitrary docker run flags, which can bypass every other restriction
+	AllowExtraArgs bool `toml:"allowExtraArgs"`
+	// AllowPrivileged lets tasks request --privileged, which gives them full control of the host
+	AllowPrivileged bool `toml:"allowPrivileged"`
+	// AllowExec lets clients run commands inside tasks with ExecInteractive, which is a shell on every task
+	AllowExec bool `toml:"allowExec"`
+	// IdleReaperEnabled stops tasks started with an idle timeout once they stay idle that long
+	IdleReaperEnabled bool `toml:"idleReaperEnabled"`
+	// IdleReaperIntervalMinutes is how often the idle reaper checks, 5 when unset
+	IdleReaperIntervalMinutes int `toml:"idleReaperIntervalMinutes"`
+	// IdleCpuPercent is the CPU usage below which a container counts as idle, 1 when unset
+	IdleCpuPercent float64 `toml:"idleCpuPercent"`
+	// MaxOutputBytes caps the docker output copied into ListTasks and StartTask/StopTask errors, 256KB when unset
+	MaxOutputBytes int `toml:"maxOutputBytes"`
+	// MaxCopyMb caps the archives CopyFromTask sends and CopyToTask accepts, 1024 when unset
+	MaxCopyMb int64 `toml:"maxCopyMb"`
+	// MaxShmSizeMb caps the /dev/shm size a task may request, unlimited when 0
+	MaxShmSizeMb int64 `toml:"maxShmSizeMb"`
+	// DockerTimeouts bounds the docker commands run for requests, per kind of command
+	DockerTimeouts DockerTimeouts `toml:"dockerTimeouts"`
+}
+
+// DockerTimeouts are in seconds. Commands that follow a task, such as logs -f, wait, exec and cp, are not bounded
+// [task.dockerTimeouts]
+// pull = 3600
+type DockerTimeouts struct {
+	// Pull bounds docker pull, 1800 when unset
+	Pull int `toml:"pull"`
+	// Run bounds docker run, create and start, 600 when unset since run pulls missing images
+	Run int `toml:"run"`
+	// Stop bounds docker stop, kill, rm and prune, 120 when unset. Keep it above the stop timeouts tasks use
+	Stop int `toml:"stop"`
+	// List bounds docker ps, images, stats and the ls of images, networks and volumes, 30 when unset
+	List int `toml:"list"`
+	// Inspect bounds docker inspect, diff, update, info and version, 30 when unset
+	Inspect int `toml:"inspect"`
 }
 
 type Config struct {
 	Server ServerConfig `toml:"server"`
+	Task   TaskConfig   `toml:"task"`
+
+	// fileName is the file the config was resolved to, where Write("") and Save("") put it back
+	fileName string
 }
 
 func (c *Config) Read(fileName string) error {
@@ -67,41 +286,92 @@ func GetCurrentDirectory() (string, error) {
 
 // GetConfig Read a config from file
 func GetConfig(fileName string) (Config, error) {
-	if fileName == "" {
-		currDir, err := GetCurrentDirectory()
-		if err != nil {
-			log.Fatalf("could not determine current directory: %v", err)
+	config, _, err := ResolveConfig(fileName)
+	return config, err
+}
+
+// SystemConfigFile is the config location used when the agent runs as a system service
+const SystemConfigFile = "/etc/cangling/config.toml"
+
+// ResolveConfig Read a config from file and report the path it was resolved to.
+// Without an explicit fileName the first existing and readable file of the following wins:
+//  1. ./config.toml
+//  2. /etc/cangling/config.toml
+//  3. ~/.cangling/config.toml
+//
+// otherwise a default config is created in the current directory.
+func ResolveConfig(fileName string) (Config, string, error) {
+	if fileName != "" {
+		config, err := readConfig(fileName)
+		config.fileName = fileName
+		return config, fileName, err
+	}
+
+	currDir, err := GetCurrentDirectory()
+	if err != nil {
+		log.Fatalf("could not determine current directory: %v", err)
+	}
+	currenDirConfig := path.Join(currDir, "config.toml")
+	candidates := []string{currenDirConfig, SystemConfigFile}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Printf("could not determine home directory: %v", err)
+	} else {
+		candidates = append(candidates, path.Join(homeDir, ".cangling", "config.toml"))
+	}
+
+	for _, candidate := range candidates {
+		config, err := readConfig(candidate)
+		if err == nil {
+			config.fileName = candidate
+			return config, candidate, nil
 		}
-		currenDirConfig := path.Join(currDir, "config.toml")
-		config, err := readConfig(currenDirConfig)
-		if err != nil {
-			// 当前目录中不存在 config.toml
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				log.Fatalf("could not determine home directory: %v", err)
-			}
-			fileName = path.Join(homeDir, ".cangling", "config.toml")
-			homeDirConfig, err := readConfig(fileName)
-			if err != nil {
-				log.Printf("home directory not found in config file: %v", err)
-				// create one
-				newConfig := createConfig()
-				data, err3 := toml.Marshal(newConfig)
-				if err3 != nil {
-					log.Printf("Error marshalling new config: %v", err3)
-				} else {
-					log.Printf("create a new config file : %s", currenDirConfig)
-					_ = os.WriteFile(currenDirConfig, data, 0644)
-				}
-				return newConfig, nil
-			}
-			return homeDirConfig, nil
+		if os.IsPermission(err) {
+			// A system config readable only by root must not stop a user's agent
+			log.Printf("skipping config file %s: %v", candidate, err)
+			continue
 		}
-		return config, err
+		if !os.IsNotExist(err) {
+			return Config{}, candidate, err
+		}
+	}
+
+	log.Printf("no config file found in %v", candidates)
+	// create one
+	newConfig := createConfig()
+	newConfig.fileName = currenDirConfig
+	data, err := toml.Marshal(newConfig)
+	if err != nil {
+		log.Printf("Error marshalling new config: %v", err)
 	} else {
-		return readConfig(fileName)
+		log.Printf("create a new config file : %s", currenDirConfig)
+		_ = os.WriteFile(currenDirConfig, data, 0644)
 	}
+	return newConfig, currenDirConfig, nil
+}
 
+// Redacted returns a copy of the config that is safe to print or send over the wire
+func (c Config) Redacted() Config {
+	redacted := c
+	redacted.Server.ServerUrl = redactUrl(c.Server.ServerUrl)
+	redacted.Server.HttpProxy = redactUrl(c.Server.HttpProxy)
+	redacted.Server.HttpsProxy = redactUrl(c.Server.HttpsProxy)
+	if c.Server.RegisterToken != "" {
+		redacted.Server.RegisterToken = "******"
+	}
+	return redacted
+}
+
+// redactUrl masks the password of a url carrying user info
+func redactUrl(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || parsed.User == nil {
+		return rawUrl
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), "xxxxx")
+	}
+	return parsed.String()
 }
 
 func (c *Config) Write(fileName string) error {
@@ -113,26 +383,29 @@ func (c *Config) Write(fileName string) error {
 	return nil
 }
 
+// Save writes the config like Write, but returns errors instead of exiting,
+// for callers that change the config while the agent is running
+func (c *Config) Save(fileName string) error {
+	return writeConfig(fileName, c)
+}
+
+// writeConfig writes the config to fileName, by default the file it was resolved to or ./config.toml
 func writeConfig(fileName string, config *Config) error {
+	if fileName == "" {
+		fileName = config.fileName
+	}
 	if fileName == "" {
 		currDir, err := GetCurrentDirectory()
 		if err != nil {
-			log.Fatalf("could not determine current directory: %v", err)
 			return err
 		}
 		fileName = path.Join(currDir, "config.toml")
 	}
 	marshal, err := toml.Marshal(config)
 	if err != nil {
-		log.Fatalf("Error: %v\n", err)
-		return err
-	}
-	err = os.WriteFile(fileName, marshal, 0644)
-	if err != nil {
-		log.Fatalf("Error: %v\n", err)
 		return err
 	}
-	return nil
+	return os.WriteFile(fileName, marshal, 0644)
 }
 
 // read config
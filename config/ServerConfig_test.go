@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveWritesBackToResolvedFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "agent.toml")
+	if err := os.WriteFile(fileName, []byte("[server]\nport = 50051\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir())
+
+	config, resolved, err := ResolveConfig(fileName)
+	if err != nil {
+		t.Fatalf("ResolveConfig: %v", err)
+	}
+	if resolved != fileName {
+		t.Errorf("resolved %q, want %q", resolved, fileName)
+	}
+	config.Server.Cordoned = true
+	if err := config.Save(""); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	saved, err := readConfig(fileName)
+	if err != nil {
+		t.Fatalf("readConfig: %v", err)
+	}
+	if !saved.Server.Cordoned || saved.Server.Port != 50051 {
+		t.Errorf("saved config = %+v, want the change written back to %s", saved.Server, fileName)
+	}
+	if _, err := os.Stat("config.toml"); !os.IsNotExist(err) {
+		t.Errorf("Save wrote ./config.toml instead of the resolved file")
+	}
+}
@@ -4,15 +4,29 @@ import (
 	"CanglingAgent/agent"
 	pb "CanglingAgent/agent"
 	"CanglingAgent/config"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/pelletier/go-toml/v2"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"text/tabwriter"
 	"time"
 )
 
@@ -40,8 +54,13 @@ var canglingServer = CanglingServer{  // Use api.CanglingServer from the api pac
 	GitHash:     GitHash,
 }
 
+var pprofAddr = ""
+var logsTail int32 = 0
+var logsSince = ""
+var psJson = false
 var registerUrl = ""
 var registerToken = ""
+var registerValidate = false
 
 func init() {
 	printBanner()
@@ -49,14 +68,31 @@ func init() {
 	if err != nil {
 		log.Fatalf("Error: %v\n", err)
 	}
+	if err := agent.ConfigureHttpClient(Config.Server); err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
 	serverCmd.Flags().Int32VarP(&port, "port", "p", 0, "Port to listen on")
+	serverCmd.Flags().StringVarP(&pprofAddr, "pprof-addr", "", "", "address of the pprof debug server, disabled when empty")
 
 	rootCmd.AddCommand(serverCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(registerCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(psCmd)
+	rootCmd.AddCommand(cordonCmd)
+	rootCmd.AddCommand(uncordonCmd)
+	rootCmd.AddCommand(maintenanceCmd)
+	rootCmd.AddCommand(benchCmd)
+
+	psCmd.Flags().BoolVarP(&psJson, "json", "", false, "print the tasks as JSON")
+
+	logsCmd.Flags().Int32VarP(&logsTail, "tail", "n", 0, "number of lines to show from the end of the logs")
+	logsCmd.Flags().StringVarP(&logsSince, "since", "", "", "show logs since timestamp or relative time (e.g. 42m)")
 
 	registerCmd.Flags().StringVarP(&registerUrl, "server", "", "", "api server's url")
 	registerCmd.Flags().StringVarP(&registerToken, "token", "", "", "api register token")
+	registerCmd.Flags().BoolVarP(&registerValidate, "validate", "", false, "check the server accepts the token without saving the registration")
 }
 
 var Config config.Config
@@ -100,9 +136,12 @@ var registerCmd = &cobra.Command{
 		nodeId, err := agent.Register(registerUrl, registerToken, Config.Server.Port, canglingServer.Version)
 		if err != nil {
 			log.Printf("Error %v", err)
+		} else if registerValidate {
+			log.Printf("server %s accepted the token, node id %v (not saved)\n", registerUrl, nodeId)
 		} else {
 			Config.Server.AgentId = nodeId
 			Config.Server.ServerUrl = registerUrl
+			Config.Server.RegisteredAt = time.Now().UnixMilli()
 			err := Config.Write("")
 			if err != nil {
 				log.Fatalf("Error: %v", err)
@@ -113,6 +152,194 @@ var registerCmd = &cobra.Command{
 	},
 }
 
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Print the effective configuration and the file it was read from",
+	Run: func(cmd *cobra.Command, args []string) {
+		effective, fileName, err := config.ResolveConfig("")
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		data, err := toml.Marshal(effective.Redacted())
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Printf("# config file: %s\n%s", fileName, data)
+	},
+}
+
+var psCmd = &cobra.Command{
+	Use:   "ps",
+	Short: "List the tasks managed by the local agent",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, conn := dialLocalAgent()
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		response, err := client.ListTasks(ctx, &pb.ListTasksRequest{ManagedOnly: true})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if psJson {
+			data, err := protojson.MarshalOptions{Multiline: true}.Marshal(response)
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		table := tabwriter.NewWriter(os.Stdout, 0, 4, 3, ' ', 0)
+		fmt.Fprintln(table, "ID\tNAME\tIMAGE\tSTATUS\tUPTIME")
+		for _, task := range response.Tasks {
+			fmt.Fprintf(table, "%.12s\t%s\t%s\t%s\t%s\n", task.Id, task.Name, task.Image, task.State, task.Status)
+		}
+		_ = table.Flush()
+	},
+}
+
+var cordonCmd = &cobra.Command{
+	Use:   "cordon",
+	Short: "Stop the local agent from accepting new tasks",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, conn := dialLocalAgent()
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		response, err := client.Cordon(ctx, &pb.Empty{})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Println(response.Message)
+	},
+}
+
+var uncordonCmd = &cobra.Command{
+	Use:   "uncordon",
+	Short: "Let the local agent accept new tasks again",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, conn := dialLocalAgent()
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		response, err := client.Uncordon(ctx, &pb.Empty{})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Println(response.Message)
+	},
+}
+
+var maintenanceCmd = &cobra.Command{
+	Use:   "maintenance <until|off>",
+	Short: "Schedule a maintenance window of the local agent, as an RFC3339 time or a duration from now (e.g. 4h)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var until int64
+		if args[0] != "off" {
+			if duration, err := time.ParseDuration(args[0]); err == nil {
+				until = time.Now().Add(duration).Unix()
+			} else if at, err := time.Parse(time.RFC3339, args[0]); err == nil {
+				until = at.Unix()
+			} else {
+				log.Fatalf("Error: '%s' is neither a duration nor an RFC3339 time", args[0])
+			}
+		}
+		client, conn := dialLocalAgent()
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		response, err := client.SetMaintenance(ctx, &pb.SetMaintenanceRequest{Until: until})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Println(response.Message)
+	},
+}
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Benchmark the CPU and GPUs of this node",
+	Run: func(cmd *cobra.Command, args []string) {
+		seconds := Config.Task.BenchSeconds
+		if seconds <= 0 {
+			seconds = 10
+		}
+		// Leave room for pulling the images
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(seconds)*2*time.Second+5*time.Minute)
+		defer cancel()
+		result, err := agent.NewGrpcServer(Config).RunBenchmark(ctx)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Printf("CPU score: %.0f\n", result.CpuScore)
+		if result.GpuScore > 0 {
+			fmt.Printf("GPU score: %.0f\n", result.GpuScore)
+		}
+		if Config.Task.BenchResultFile != "" {
+			if err := agent.SaveBenchResult(Config.Task.BenchResultFile, result); err != nil {
+				log.Fatalf("Failed to save result: %v", err)
+			}
+			fmt.Printf("Saved to %s, reported with the next heartbeat\n", Config.Task.BenchResultFile)
+		}
+	},
+}
+
+// dialLocalAgent connects to the gRPC server of the agent running on this node
+func dialLocalAgent() (pb.AgentServiceClient, *grpc.ClientConn) {
+	conn, err := grpc.NewClient(localAgentAddress(Config.Server),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		// Match the server's limits, so large task lists and log chunks are accepted
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(megabytesOrDefault(Config.Server.MaxSendMsgSizeMb, 16)),
+			grpc.MaxCallSendMsgSize(megabytesOrDefault(Config.Server.MaxRecvMsgSizeMb, 16)),
+		))
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	return pb.NewAgentServiceClient(conn), conn
+}
+
+// localAgentAddress is the address the local agent listens on, loopback unless it is bound to a specific IP
+func localAgentAddress(serverConfig config.ServerConfig) string {
+	host := "127.0.0.1"
+	if ip := net.ParseIP(serverConfig.BindAddress); ip != nil && !ip.IsUnspecified() {
+		host = serverConfig.BindAddress
+	}
+	return net.JoinHostPort(host, fmt.Sprintf("%d", serverConfig.Port))
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs <name>",
+	Short: "Follow the logs of a task through the local agent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		client, conn := dialLocalAgent()
+		defer conn.Close()
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		stream, err := client.StreamLogs(ctx, &pb.StreamLogsRequest{
+			Name:  args[0],
+			Tail:  logsTail,
+			Since: logsSince,
+		})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF || ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			_, _ = os.Stdout.Write(chunk.Data)
+		}
+	},
+}
+
 func startAgent(cmd *cobra.Command, args []string) {
 	if port == 0 {
 		port = Config.Server.Port
@@ -124,45 +351,114 @@ func startAgent(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	if pprofAddr == "" {
+		pprofAddr = Config.Server.PprofAddr
+	}
+	if pprofAddr != "" {
+		startPprof(pprofAddr)
+	}
+
+	shutdownTracing, err := agent.InitTracing(context.Background(), Config.Server, canglingServer.Version)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
+
 	// 1. Create a TCP listener
-	lis, err := net.Listen("tcp", ":"+fmt.Sprintf("%d", port))
+	bindAddress := Config.Server.BindAddress
+	if bindAddress != "" && net.ParseIP(bindAddress) == nil {
+		log.Fatalf("invalid bindAddress %q in config: must be an IP address", bindAddress)
+	}
+	listenAddress := net.JoinHostPort(bindAddress, fmt.Sprintf("%d", port))
+	lis, err := net.Listen("tcp", listenAddress)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
 	// 2. Create the gRPC server instance
-	s := grpc.NewServer()
-	pb.RegisterAgentServiceServer(s, pb.NewGrpcServer())
-	reflection.Register(s)
+	streams := &agent.StreamTracker{}
+	interceptors, streamInterceptors := serverInterceptors(Config.Server)
+	s := grpc.NewServer(grpcServerOptions(Config.Server, streams, interceptors, streamInterceptors)...)
+	agentServer := pb.NewGrpcServer(Config)
+	pb.RegisterAgentServiceServer(s, agentServer)
+	if !Config.Server.DisableReflection {
+		reflection.Register(s)
+	}
 
 	// 3. Start gRPC Server (Non-blocking)
-	go func() {
-		fmt.Printf("gRPC server listening on :%d\n", port)
-		if err := s.Serve(lis); err != nil {
-			// Use Errorf instead of Fatalf here, since we are in a separate goroutine
-			log.Printf("gRPC failed to serve: %v", err)
+	var shuttingDown atomic.Bool
+	go serveWithRestart(s, lis, listenAddress, &shuttingDown)
+
+	var gateway *http.Server
+	if Config.Server.GatewayAddr != "" {
+		handler := agent.NewGateway(agentServer, kilobytesOrDefault(Config.Server.GatewayMaxBodyKb, 1024), interceptors, streamInterceptors)
+		if agent.TracingEnabled(Config.Server) {
+			handler = otelhttp.NewHandler(handler, "gateway")
 		}
-	}()
+		gateway = &http.Server{Addr: Config.Server.GatewayAddr, Handler: handler}
+		go func() {
+			log.Printf("REST gateway listening on %s", Config.Server.GatewayAddr)
+			if err := gateway.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("REST gateway failed: %v", err)
+			}
+		}()
+	}
+
+	// Track exits of managed containers for the heartbeat
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	go agent.WatchExits(watchCtx, Config.Task.NamePrefix)
+	go agent.RunJanitor(watchCtx, Config.Task)
+	go agent.RunIdleReaper(watchCtx, Config.Task)
 
 	// 4. Setup Periodic Agent Reporting
+	if err := registerOnStartup(agentServer); err != nil {
+		log.Printf("Automatic registration failed, retrying with the heartbeat: %v", err)
+	}
 	// Create a channel to signal when to stop the reporting goroutine
 	done := make(chan struct{})
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop() // Ensure ticker is stopped when startAgent exits
+	const heartbeatInterval = 5 * time.Second
+	schedule := agent.NewHeartbeatSchedule(Config.Server, heartbeatInterval)
+	watchdog, err := agent.NewReportWatchdog(Config.Server)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	firstDelay := schedule.FirstDelay()
+	timer := time.NewTimer(firstDelay)
+	defer timer.Stop() // Ensure timer is stopped when startAgent exits
 
 	// Run the scheduler loop in a non-blocking goroutine
 	go func() {
-		log.Println("Starting periodic agent report (every 5s)...")
+		log.Printf("Starting periodic agent report (every 5s with jitter, first in %v)...", firstDelay.Round(time.Millisecond))
 		for {
 			select {
 			case <-done:
 				return
-			case <-ticker.C:
-				// FIX: The return statement was removed here. The loop continues.
+			case <-timer.C:
+				if err := registerOnStartup(agentServer); err != nil {
+					log.Printf("Automatic registration failed, retrying: %v", err)
+					timer.Reset(schedule.Next())
+					continue
+				}
 				err2 := agent.ReportAgentToServer(Config, canglingServer.Version)
 				if err2 != nil {
 					log.Printf("Error during agent report: %v", err2)
+					if age := agent.HeartbeatAge(); Config.Server.ServerUrl != "" && age > 3*heartbeatInterval {
+						log.Printf("WARNING: no heartbeat accepted by the server for %v, this node is invisible to the control plane", age.Round(time.Second))
+					}
+					if Config.Server.ServerUrl != "" {
+						watchdog.Failure(err2)
+					}
+				} else {
+					watchdog.Success()
 				}
+				timer.Reset(schedule.Next())
 			}
 		}
 	}()
@@ -175,12 +471,169 @@ func startAgent(cmd *cobra.Command, args []string) {
 	// 6. Gracefully Shut Down
 	log.Println("Received shutdown signal. Stopping agent report...")
 	close(done) // Signal the reporting goroutine to stop
+	stopWatching()
 
 	log.Println("Shutting down gRPC server...")
-	s.GracefulStop()
+	shuttingDown.Store(true)
+	// GracefulStop waits for every open stream, so a client following logs could block shutdown forever
+	drainTimeout := secondsOrDefault(Config.Server.ShutdownTimeoutSeconds, 10)
+	if gateway != nil {
+		gatewayCtx, cancelGateway := context.WithTimeout(context.Background(), drainTimeout)
+		if err := gateway.Shutdown(gatewayCtx); err != nil {
+			log.Printf("REST gateway did not drain in time: %v", err)
+			_ = gateway.Close()
+		}
+		cancelGateway()
+	}
+	drained := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(drainTimeout):
+		log.Printf("Drain timeout of %v exceeded, forcibly closing %d open streams", drainTimeout, streams.Active())
+		s.Stop()
+		<-drained
+	}
 	log.Println("Server exited successfully.")
 }
 
+// registerOnStartup registers an agent that has no AgentId yet when a registration token is configured,
+// and saves the registration through the running server. It does nothing for a registered agent
+func registerOnStartup(agentServer *pb.GrpcServer) error {
+	serverUrl, token := Config.Server.AutoRegistration()
+	if token == "" {
+		return nil
+	}
+	nodeId, err := agent.Register(serverUrl, token, Config.Server.Port, canglingServer.Version)
+	if err != nil {
+		return err
+	}
+	Config.Server.AgentId = nodeId
+	Config.Server.ServerUrl = serverUrl
+	Config.Server.RegisteredAt = time.Now().UnixMilli()
+	Config.Server.RegisterToken = ""
+	log.Printf("Registered to %s as node %s", serverUrl, nodeId)
+	if err := agentServer.SaveRegistration(nodeId, serverUrl, Config.Server.RegisteredAt); err != nil {
+		log.Printf("Failed to save the registration, it is lost on restart: %v", err)
+	}
+	return nil
+}
+
+// serveRestartAttempts is how many times a crashed gRPC server is rebound before the agent exits
+const serveRestartAttempts = 5
+
+// serveWithRestart runs the gRPC server and supervises it: when Serve exits unexpectedly the listener
+// is rebound with exponential backoff, and if that keeps failing the process exits so an orchestrator
+// can restart it. An exit caused by shutdown is not treated as a crash.
+func serveWithRestart(s *grpc.Server, lis net.Listener, listenAddress string, shuttingDown *atomic.Bool) {
+	for {
+		fmt.Printf("gRPC server listening on %s\n", lis.Addr())
+		err := s.Serve(lis)
+		if shuttingDown.Load() || err == nil || errors.Is(err, grpc.ErrServerStopped) {
+			return
+		}
+		log.Printf("gRPC server crashed: %v", err)
+
+		lis = nil
+		backoff := time.Second
+		for attempt := 1; attempt <= serveRestartAttempts && lis == nil; attempt++ {
+			time.Sleep(backoff)
+			backoff *= 2
+			if shuttingDown.Load() {
+				return
+			}
+			lis, err = net.Listen("tcp", listenAddress)
+			if err != nil {
+				log.Printf("Rebinding %s failed (attempt %d/%d): %v", listenAddress, attempt, serveRestartAttempts, err)
+			}
+		}
+		if lis == nil {
+			log.Fatalf("gRPC server could not be restarted after %d attempts, exiting", serveRestartAttempts)
+		}
+	}
+}
+
+// serverInterceptors returns the unary and stream interceptors. The unary ones are shared by the gRPC server
+// and the REST gateway, and both kinds share one rate limiter, so rate limits count every call of a client
+func serverInterceptors(serverConfig config.ServerConfig) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
+	interceptors := []grpc.UnaryServerInterceptor{agent.UnaryRequestId, agent.UnaryAccessLog}
+	streamInterceptors := []grpc.StreamServerInterceptor{agent.StreamRequestId, agent.StreamAccessLog}
+	if serverConfig.RateLimit > 0 {
+		limiter := agent.NewRateLimiter(serverConfig.RateLimit, serverConfig.RateBurst, agent.MutatingMethods)
+		interceptors = append(interceptors, limiter.UnaryInterceptor)
+		streamInterceptors = append(streamInterceptors, limiter.StreamInterceptor)
+	}
+	return interceptors, streamInterceptors
+}
+
+// grpcServerOptions builds the gRPC server options from the server config
+func grpcServerOptions(serverConfig config.ServerConfig, streams *agent.StreamTracker, interceptors []grpc.UnaryServerInterceptor, streamInterceptors []grpc.StreamServerInterceptor) []grpc.ServerOption {
+	keepaliveTime := secondsOrDefault(serverConfig.KeepaliveTimeSeconds, 60)
+	keepaliveTimeout := secondsOrDefault(serverConfig.KeepaliveTimeoutSeconds, 20)
+	keepaliveMinClient := secondsOrDefault(serverConfig.KeepaliveMinClientSeconds, 30)
+	options := []grpc.ServerOption{
+		// Ping idle clients so connections silently dropped by NAT or load balancers get cleaned up
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    keepaliveTime,
+			Timeout: keepaliveTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             keepaliveMinClient,
+			PermitWithoutStream: true,
+		}),
+		grpc.ChainStreamInterceptor(append(streamInterceptors, streams.StreamInterceptor)...),
+		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.MaxRecvMsgSize(megabytesOrDefault(serverConfig.MaxRecvMsgSizeMb, 16)),
+		grpc.MaxSendMsgSize(megabytesOrDefault(serverConfig.MaxSendMsgSizeMb, 16)),
+	}
+	if agent.TracingEnabled(serverConfig) {
+		// Server spans for every call, continuing the trace of the caller
+		options = append(options, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	}
+	return options
+}
+
+func megabytesOrDefault(megabytes int, defaultMegabytes int) int {
+	if megabytes <= 0 {
+		megabytes = defaultMegabytes
+	}
+	return megabytes * 1024 * 1024
+}
+
+func kilobytesOrDefault(kilobytes int, defaultKilobytes int) int {
+	if kilobytes <= 0 {
+		kilobytes = defaultKilobytes
+	}
+	return kilobytes * 1024
+}
+
+func secondsOrDefault(seconds int, defaultSeconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// startPprof serves net/http/pprof in the background.
+// An address without a host ("6060" or ":6060") is bound to localhost, since pprof exposes process internals.
+func startPprof(addr string) {
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+	go func() {
+		log.Printf("pprof debug server listening on %s", addr)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("pprof server failed: %v", err)
+		}
+	}()
+}
+
 func main() {
 	// Execute the root command. Cobra will handle parsing args and calling the right command.
 	if err := rootCmd.Execute(); err != nil {